@@ -8,6 +8,8 @@ const (
 	ACT_DELLINE
 	ACT_DELWORD
 	ACT_DETABULATE
+	ACT_DELREGION
+	ACT_YANK
 )
 
 type ActionKind int
@@ -23,6 +25,10 @@ type ActionResult struct {
 	Lineno, Col int
 }
 
+type position struct {
+	lineno, col int
+}
+
 func NewInsert(lineno, col int, rs []rune) *Action {
 	return &Action{
 		kind:   ACT_RUNES,
@@ -79,3 +85,25 @@ func NewDetabulate(lineno, col int) *Action {
 		col:    col,
 	}
 }
+
+// NewDelRegion deletes the text between the start position and the
+// end position. The rune at the end position is not deleted.
+func NewDelRegion(startlineno, startcol, endlineno, endcol int) *Action {
+	return &Action{
+		kind:   ACT_DELREGION,
+		lineno: startlineno,
+		col:    startcol,
+		data:   position{lineno: endlineno, col: endcol},
+	}
+}
+
+// NewYank inserts text, which may span multiple lines, to the given
+// position.
+func NewYank(lineno, col int, text [][]rune) *Action {
+	return &Action{
+		kind:   ACT_YANK,
+		lineno: lineno,
+		col:    col,
+		data:   text,
+	}
+}
@@ -1,28 +1,41 @@
 package buffer
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"os"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/susji/ked/config"
 	"github.com/susji/ked/gapbuffer"
+	"github.com/susji/ked/search"
+	"github.com/susji/ked/util"
+	"golang.org/x/text/encoding"
 )
 
 type Buffer struct {
 	lines   []*gapbuffer.GapBuffer
 	mods    []*modification
+	redos   []*modification
 	TabSize int
+	Format  Format
+	// groupid identifies the undo group, which new modifications
+	// are assigned into. groupdepth tracks the nesting of
+	// BeginGroup and EndGroup calls.
+	groupid    uint64
+	groupdepth int
+	// sealed prevents the next modification from being coalesced
+	// into the previous undo group.
+	sealed bool
 }
 
 func New(rawlines [][]rune) *Buffer {
 	ret := &Buffer{
 		TabSize: config.DEFAULT_TABSIZE,
+		Format:  DefaultFormat,
 	}
 	ret.lines = []*gapbuffer.GapBuffer{}
 	if len(rawlines) == 0 {
@@ -35,33 +48,127 @@ func New(rawlines [][]rune) *Buffer {
 }
 
 func NewFromReader(r io.Reader) (*Buffer, error) {
-	lines := []*gapbuffer.GapBuffer{}
-	s := bufio.NewScanner(r)
-	for s.Scan() {
-		lines = append(lines, gapbuffer.NewFrom([]rune(string(s.Bytes()))))
+	return NewFromReaderEncoding(r, nil)
+}
+
+// NewFromReaderEncoding decodes the contents of r from enc, which may
+// be nil for UTF-8.
+func NewFromReaderEncoding(r io.Reader, enc encoding.Encoding) (*Buffer, error) {
+	if enc != nil {
+		r = enc.NewDecoder().Reader(r)
 	}
-	if err := s.Err(); err != nil {
+	lines, format, err := readlines(r)
+	if err != nil {
 		return nil, err
 	}
-	if len(lines) == 0 {
-		lines = append(lines, gapbuffer.New(gapbuffer.DEFAULTSZ))
-	}
+	format.Encoding = enc
 	return &Buffer{
 		lines:   lines,
 		TabSize: config.DEFAULT_TABSIZE,
+		Format:  format,
 	}, nil
 }
 
+// BeginGroup starts an undo group. All modifications done before
+// the matching EndGroup call are undone and redone as a single unit.
+// Groups may be nested, in which case the outermost group wins.
+func (b *Buffer) BeginGroup() {
+	if b.groupdepth == 0 {
+		b.groupid++
+	}
+	b.groupdepth++
+}
+
+func (b *Buffer) EndGroup() {
+	if b.groupdepth == 0 {
+		panic("EndGroup: no group to end")
+	}
+	b.groupdepth--
+}
+
+// Checkpoint returns a marker for JoinSince. Modifications done after
+// it are not coalesced into earlier undo groups.
+func (b *Buffer) Checkpoint() uint64 {
+	b.sealed = true
+	return b.groupid
+}
+
+// JoinSince joins the undo groups done after checkpoint into one.
+// Unlike with BeginGroup, the groups may be undone one by one until
+// they are joined.
+func (b *Buffer) JoinSince(checkpoint uint64) {
+	b.groupid++
+	for i := len(b.mods) - 1; i >= 0 && b.mods[i].group > checkpoint; i-- {
+		b.mods[i].group = b.groupid
+	}
+	b.sealed = true
+}
+
+// UndoModification reverts the most recent undo group.
 func (b *Buffer) UndoModification() *ActionResult {
 	if len(b.mods) == 0 {
 		return nil
 	}
 	var mod *modification
-restart:
+	group := b.mods[len(b.mods)-1].group
+	for len(b.mods) > 0 && b.mods[len(b.mods)-1].group == group {
+		n := len(b.mods) - 1
+		mod, b.mods = b.mods[n], b.mods[:n]
+
+		log.Printf("[UndoModification]: %+v\n", mod)
+		b.revert(mod)
+		b.redos = append(b.redos, mod)
+	}
+	b.sealed = true
+	return &ActionResult{Lineno: mod.lineno, Col: mod.col}
+}
+
+// RedoModification re-applies the undo group most recently reverted
+// by UndoModification. The redo stack is forgotten as soon as the
+// buffer is modified by other means.
+func (b *Buffer) RedoModification() *ActionResult {
+	if len(b.redos) == 0 {
+		return nil
+	}
+	var mod *modification
+	group := b.redos[len(b.redos)-1].group
+	for len(b.redos) > 0 && b.redos[len(b.redos)-1].group == group {
+		n := len(b.redos) - 1
+		mod, b.redos = b.redos[n], b.redos[:n]
+
+		log.Printf("[RedoModification]: %+v\n", mod)
+		b.apply(mod)
+		b.mods = append(b.mods, mod)
+	}
+	b.sealed = true
+	return &ActionResult{Lineno: mod.lineno, Col: mod.col}
+}
+
+// UndoYank removes the text inserted by the most recent modification,
+// if it was a yank. Unlike UndoModification, the rest of its undo group
+// is kept, and the yank cannot be redone.
+func (b *Buffer) UndoYank() *ActionResult {
+	if len(b.mods) == 0 {
+		return nil
+	}
 	n := len(b.mods) - 1
-	mod, b.mods = b.mods[n], b.mods[:n]
+	mod := b.mods[n]
+	if mod.kind != MOD_INSERTTEXT {
+		return nil
+	}
+	log.Printf("[UndoYank]: %+v\n", mod)
+	b.mods = b.mods[:n]
+	b.revert(mod)
+	b.sealed = true
+	return &ActionResult{Lineno: mod.lineno, Col: mod.col}
+}
 
-	log.Printf("[UndoModification]: %+v\n", mod)
+func (b *Buffer) Redoable() bool {
+	return len(b.redos) > 0
+}
+
+// revert undoes the effects of a single modification.
+func (b *Buffer) revert(mod *modification) {
 	switch mod.kind {
 	case MOD_INSERTRUNES:
 		data := mod.data.([]rune)
@@ -93,55 +200,166 @@ restart:
 		}
 		b.lines[lineno+1].SetCursor(0).Insert(data)
 	case MOD_DELETELINE:
-		lineno := mod.lineno
-		left := b.lines[:lineno]
-		right := b.lines[lineno:]
-		newline := gapbuffer.New(gapbuffer.DEFAULTSZ)
-		newlines := make([]*gapbuffer.GapBuffer, len(left)+len(right)+1)
-		copy(newlines, left)
-		newlines[len(left)] = newline
-		copy(newlines[len(left)+1:], right)
-		b.lines = newlines
-	case MOD_REPLACERUNES:
-		rep := mod.data.(*replacedata)
-		lineno := mod.lineno
-		col := mod.col
-		for i := 0; i < len(rep.to); i++ {
-			b.lines[lineno].SetCursor(col + 1).Delete()
+		b.NewLine(mod.lineno)
+	case MOD_RELOAD:
+		b.setlines(mod.data.(*reloaddata).from)
+	case MOD_INSERTTEXT:
+		endlineno, endcol := textend(mod.lineno, mod.col, mod.data.([][]rune))
+		b.deletetext(mod.lineno, mod.col, endlineno, endcol)
+	case MOD_DELETETEXT:
+		b.inserttext(mod.lineno, mod.col, mod.data.([][]rune))
+	}
+}
+
+// apply redoes the effects of a single modification, which has been
+// reverted earlier.
+func (b *Buffer) apply(mod *modification) {
+	switch mod.kind {
+	case MOD_INSERTRUNES:
+		b.lines[mod.lineno].SetCursor(mod.col).Insert(mod.data.([]rune))
+	case MOD_LINEFEED:
+		line := b.lines[mod.lineno].Get()
+		b.lines[mod.lineno].Clear().Insert(line[:mod.col])
+		b.NewLine(mod.lineno + 1).Insert(line[mod.col:])
+	case MOD_DELETERUNES:
+		data := mod.data.([]rune)
+		for i := 0; i < len(data); i++ {
+			b.lines[mod.lineno].SetCursor(mod.col + 1).Delete()
+		}
+	case MOD_MOVERUNES:
+		data := mod.data.([]rune)
+		b.lines[mod.lineno].SetCursor(mod.col).Insert(data)
+		for i := 0; i < len(data); i++ {
+			b.lines[mod.lineno+1].SetCursor(1).Delete()
 		}
-		b.lines[lineno].SetCursor(col).Insert(rep.from)
-	case MOD_BREAKPOINT:
-		// Breakpoint is used to break the chaining of undo actions.
+	case MOD_DELETELINE:
+		copy(b.lines[mod.lineno:], b.lines[mod.lineno+1:])
+		b.lines[len(b.lines)-1] = nil
+		b.lines = b.lines[:len(b.lines)-1]
+	case MOD_RELOAD:
+		b.setlines(mod.data.(*reloaddata).to)
+	case MOD_INSERTTEXT:
+		b.inserttext(mod.lineno, mod.col, mod.data.([][]rune))
+	case MOD_DELETETEXT:
+		endlineno, endcol := textend(mod.lineno, mod.col, mod.data.([][]rune))
+		b.deletetext(mod.lineno, mod.col, endlineno, endcol)
 	}
-	// Execute all sequential modifications of the same kind.
-	if len(b.mods) > 0 && mod.kind == b.mods[len(b.mods)-1].kind {
-		goto restart
+}
+
+func (b *Buffer) setlines(rawlines [][]rune) {
+	b.lines = make([]*gapbuffer.GapBuffer, 0, len(rawlines))
+	for _, rawline := range rawlines {
+		b.lines = append(b.lines, gapbuffer.NewFrom(rawline))
 	}
-	return &ActionResult{Lineno: mod.lineno, Col: mod.col}
 }
 
 func (b *Buffer) modify(mod *modification) {
+	if b.groupdepth == 0 {
+		b.groupid++
+	}
+	mod.group = b.groupid
+	mod.when = time.Now()
+	if b.coalesces(mod) {
+		mod.group = b.mods[len(b.mods)-1].group
+	}
+	b.sealed = false
+
 	log.Printf("[modify] %+v\n", mod)
 	b.mods = append(b.mods, mod)
+	b.redos = nil
+}
+
+// coalesces determines whether mod should be joined to the previous
+// undo group. This is done to undo typing word-by-word instead of
+// rune-by-rune: Insertions continuing where the previous insertion
+// ended are coalesced until a new word begins or the user has been
+// idle for long enough.
+func (b *Buffer) coalesces(mod *modification) bool {
+	if b.sealed || b.groupdepth > 1 || len(b.mods) == 0 {
+		return false
+	}
+	prev := b.mods[len(b.mods)-1]
+	if mod.kind != MOD_INSERTRUNES || prev.kind != MOD_INSERTRUNES {
+		return false
+	}
+	prevdata := prev.data.([]rune)
+	data := mod.data.([]rune)
+	if len(prevdata) == 0 || len(data) == 0 ||
+		prev.lineno != mod.lineno ||
+		prev.col+len(prevdata) != mod.col ||
+		mod.when.Sub(prev.when) >= config.UNDO_IDLE {
+		return false
+	}
+	prevdelim := strings.ContainsRune(config.WORD_DELIMS, prevdata[len(prevdata)-1])
+	delim := strings.ContainsRune(config.WORD_DELIMS, data[0])
+	return !prevdelim || delim
+}
+
+// Reload replaces the buffer contents with data read from r. The
+// replacement is recorded as a single modification so it can be
+// undone.
+func (b *Buffer) Reload(r io.Reader) error {
+	nb, err := NewFromReaderEncoding(r, b.Format.Encoding)
+	if err != nil {
+		return err
+	}
+	rd := &reloaddata{
+		from: b.ToRunes(),
+		to:   nb.ToRunes(),
+	}
+	b.modify(&modification{
+		kind: MOD_RELOAD,
+		data: rd,
+	})
+	b.setlines(rd.to)
+	b.Format = nb.Format
+	return nil
 }
 
 func (b *Buffer) Save(filepath string) error {
 	if len(filepath) == 0 {
 		panic("Save: no file backing this buffer")
 	}
-	data := []byte{}
-	for _, gb := range b.lines {
-		linedata := []byte(string(gb.Get()))
-		linedata = append(linedata, '\n')
-		data = append(data, linedata...)
+	data, err := b.Bytes()
+	if err != nil {
+		return err
 	}
-	if err := os.WriteFile(filepath, data, 0644); err != nil {
+	if err := util.WriteFileAtomic(filepath, data, 0644); err != nil {
 		return err
 	}
-	b.modify(&modification{kind: MOD_BREAKPOINT})
+	// Make sure we do not coalesce modifications across saves.
+	b.sealed = true
+	// The line endings are no longer mixed on disk.
+	b.Format.Mixed = false
 	return nil
 }
 
+// Bytes returns the buffer contents as they would be saved. A buffer
+// with a single empty line is saved as an empty file. Contents which
+// cannot be represented in the buffer's encoding are an error.
+func (b *Buffer) Bytes() ([]byte, error) {
+	data := []byte{}
+	if b.Format.BOM {
+		data = append(data, BOM...)
+	}
+	eol := b.Format.EOL.bytes()
+	for i, gb := range b.lines {
+		data = append(data, []byte(string(gb.Get()))...)
+		if i < len(b.lines)-1 || b.Format.FinalNewline {
+			data = append(data, eol...)
+		}
+	}
+	if b.Format.Encoding == nil {
+		return data, nil
+	}
+	encoded, err := b.Format.Encoding.NewEncoder().Bytes(data)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"cannot encode as %s: %w", encodingname(b.Format.Encoding), err)
+	}
+	return encoded, nil
+}
+
 func (b *Buffer) NewLine(pos int) *gapbuffer.GapBuffer {
 	if pos < 0 || len(b.lines) < pos {
 		panic(fmt.Sprintf("NewLine: invalid pos=%d", pos))
@@ -249,6 +467,107 @@ func (b *Buffer) backspace(act *Action) ActionResult {
 	return ActionResult{Lineno: lineno, Col: col}
 }
 
+// Region returns a copy of the text between the start and end
+// positions. The rune at the end position is not included. Text
+// spanning multiple lines is returned as multiple lines.
+func (b *Buffer) Region(startlineno, startcol, endlineno, endcol int) [][]rune {
+	if startlineno == endlineno {
+		return [][]rune{b.lines[startlineno].Get()[startcol:endcol]}
+	}
+	ret := [][]rune{b.lines[startlineno].Get()[startcol:]}
+	for lineno := startlineno + 1; lineno < endlineno; lineno++ {
+		ret = append(ret, b.lines[lineno].Get())
+	}
+	return append(ret, b.lines[endlineno].Get()[:endcol])
+}
+
+// textend returns the position right after text, which begins from
+// the given position.
+func textend(lineno, col int, text [][]rune) (int, int) {
+	last := len(text) - 1
+	if last == 0 {
+		return lineno, col + len(text[0])
+	}
+	return lineno + last, len(text[last])
+}
+
+// inserttext inserts multiline text into the given position and
+// returns the position right after the inserted text.
+func (b *Buffer) inserttext(lineno, col int, text [][]rune) (int, int) {
+	if len(text) == 1 {
+		b.lines[lineno].SetCursor(col).Insert(text[0])
+		return textend(lineno, col, text)
+	}
+	line := b.lines[lineno].Get()
+	first := append(append([]rune{}, line[:col]...), text[0]...)
+	lastn := len(text) - 1
+	last := append(append([]rune{}, text[lastn]...), line[col:]...)
+
+	newlines := make([]*gapbuffer.GapBuffer, 0, len(b.lines)+lastn)
+	newlines = append(newlines, b.lines[:lineno]...)
+	newlines = append(newlines, gapbuffer.NewFrom(first))
+	for _, middle := range text[1:lastn] {
+		newlines = append(newlines, gapbuffer.NewFrom(middle))
+	}
+	newlines = append(newlines, gapbuffer.NewFrom(last))
+	newlines = append(newlines, b.lines[lineno+1:]...)
+	b.lines = newlines
+	return textend(lineno, col, text)
+}
+
+// deletetext removes the text between start and end positions and
+// returns the removed text.
+func (b *Buffer) deletetext(startlineno, startcol, endlineno, endcol int) [][]rune {
+	text := b.Region(startlineno, startcol, endlineno, endcol)
+	if startlineno == endlineno {
+		for i := startcol; i < endcol; i++ {
+			b.lines[startlineno].SetCursor(startcol + 1).Delete()
+		}
+		return text
+	}
+	joined := append(
+		append([]rune{}, b.lines[startlineno].Get()[:startcol]...),
+		b.lines[endlineno].Get()[endcol:]...)
+
+	newlines := make([]*gapbuffer.GapBuffer, 0, len(b.lines)-(endlineno-startlineno))
+	newlines = append(newlines, b.lines[:startlineno]...)
+	newlines = append(newlines, gapbuffer.NewFrom(joined))
+	newlines = append(newlines, b.lines[endlineno+1:]...)
+	b.lines = newlines
+	return text
+}
+
+func (b *Buffer) delregion(act *Action) ActionResult {
+	end := act.data.(position)
+	if end.lineno < act.lineno || (end.lineno == act.lineno && end.col < act.col) {
+		panic(fmt.Sprintf("delregion: end (%d, %d) before start (%d, %d)",
+			end.lineno, end.col, act.lineno, act.col))
+	}
+	text := b.deletetext(act.lineno, act.col, end.lineno, end.col)
+	b.modify(&modification{
+		kind:   MOD_DELETETEXT,
+		lineno: act.lineno,
+		col:    act.col,
+		data:   text,
+	})
+	return ActionResult{Lineno: act.lineno, Col: act.col}
+}
+
+func (b *Buffer) yank(act *Action) ActionResult {
+	text := act.data.([][]rune)
+	if len(text) == 0 {
+		return ActionResult{Lineno: act.lineno, Col: act.col}
+	}
+	lineno, col := b.inserttext(act.lineno, act.col, text)
+	b.modify(&modification{
+		kind:   MOD_INSERTTEXT,
+		lineno: act.lineno,
+		col:    act.col,
+		data:   text,
+	})
+	return ActionResult{Lineno: lineno, Col: col}
+}
+
 func (b *Buffer) Lines() int {
 	return len(b.lines)
 }
@@ -286,36 +605,18 @@ func (b *Buffer) Replace(what, with []rune) (lineno, col int) {
 	return b.ReplaceRange(what, with, limits)
 }
 
+// ReplaceRange replaces all case-insensitive occurrences of what
+// within limits. The position of the last replacement is returned.
 func (b *Buffer) ReplaceRange(what, with []rune, limits *SearchLimit) (lineno, col int) {
-	lastlineno, lastcol := -1, -1
-	for {
-		lineno, col = b.SearchRange(what, limits)
-		if lineno == -1 || col == -1 {
-			break
-		}
-
-		lastlineno = lineno
-		lastcol = col
-		rep := b.lines[lineno].Get()[col : col+len(what)]
-
-		b.modify(&modification{
-			kind:   MOD_REPLACERUNES,
-			lineno: lineno,
-			col:    col,
-			data: &replacedata{
-				from: rep,
-				to:   with,
-			},
-		})
-		for n := 0; n < len(what); n++ {
-			b.lines[lineno].SetCursor(col + 1).Delete()
-		}
-		b.lines[lineno].SetCursor(col).Insert(with)
-
-		limits.StartLineno = lineno
-		limits.StartCol = col + len(with)
+	m, err := search.Compile(string(what), search.Options{})
+	if err != nil {
+		panic(fmt.Sprintf("ReplaceRange: literal did not compile: %v", err))
 	}
-	return lastlineno, lastcol
+	n, last := b.ReplaceAll(m, string(with), limits)
+	if n == 0 {
+		return -1, -1
+	}
+	return last.StartLineno, last.StartCol
 }
 
 func (b *Buffer) Search(term []rune) (lineno, col int) {
@@ -326,29 +627,18 @@ func (b *Buffer) Search(term []rune) (lineno, col int) {
 	return b.SearchRange(term, limits)
 }
 
+// SearchRange finds the first case-insensitive occurrence of term
+// within limits.
 func (b *Buffer) SearchRange(term []rune, limits *SearchLimit) (lineno, col int) {
-	sterm := strings.ToLower(string(term))
-	for lineno := limits.StartLineno; lineno <= limits.EndLineno; lineno++ {
-		line := b.GetLine(lineno)
-		if len(line) == 0 {
-			continue
-		}
-		a, b := 0, len(line)
-		if lineno == limits.StartLineno {
-			a = limits.StartCol
-		}
-		if lineno == limits.EndLineno {
-			b = limits.EndCol
-		}
-		line = line[a:b]
-		s := strings.ToLower(string(line))
-		col := strings.Index(s, sterm)
-		//log.Printf("ZZZ: %q/%q -> %d\n", sterm, s, col)
-		if col >= 0 {
-			return lineno, col + a
-		}
+	m, err := search.Compile(string(term), search.Options{})
+	if err != nil {
+		panic(fmt.Sprintf("SearchRange: literal did not compile: %v", err))
+	}
+	match, ok := b.Find(m, limits)
+	if !ok {
+		return -1, -1
 	}
-	return -1, -1
+	return match.StartLineno, match.StartCol
 }
 
 func (b *Buffer) NextRune(lineno, col int) (rune, error) {
@@ -498,6 +788,10 @@ func (b *Buffer) detabulate(act *Action) ActionResult {
 // for outsiders to generate changes in buffer contents. Here
 // we also handle all the relevant book-keepping for undo.
 func (b *Buffer) Perform(act *Action) ActionResult {
+	// Each action is undone as a whole.
+	b.BeginGroup()
+	defer b.EndGroup()
+
 	dispatch := map[ActionKind]ActionFunc{
 		ACT_RUNES:          b.insertrune,
 		ACT_BACKSPACE:      b.backspace,
@@ -506,10 +800,23 @@ func (b *Buffer) Perform(act *Action) ActionResult {
 		ACT_DELLINE:        b.deleteline,
 		ACT_DETABULATE:     b.detabulate,
 		ACT_DELWORD:        b.delword,
+		ACT_DELREGION:      b.delregion,
+		ACT_YANK:           b.yank,
 	}
 	return dispatch[act.kind](act)
 }
 
+// Text returns the lines as UTF-8, each followed by a newline,
+// regardless of Format. Unlike Bytes, it cannot fail.
+func (b *Buffer) Text() []byte {
+	data := []byte{}
+	for _, gb := range b.lines {
+		data = append(data, []byte(string(gb.Get()))...)
+		data = append(data, '\n')
+	}
+	return data
+}
+
 func (b *Buffer) ToRunes() [][]rune {
 	ret := [][]rune{}
 	for _, line := range b.lines {
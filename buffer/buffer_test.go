@@ -2,13 +2,16 @@ package buffer_test
 
 import (
 	"fmt"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/susji/ked/buffer"
 	"github.com/susji/ked/config"
 	ta "github.com/susji/ked/internal/testutil"
+	"github.com/susji/ked/search"
 )
 
 func TestSanity(t *testing.T) {
@@ -491,9 +494,17 @@ func TestReplace(t *testing.T) {
 		got2,
 		want2)
 
-	// Undo and verify.
+	// Each replace is undone as a unit, so undo twice and verify.
 	b.UndoModification()
 	got3 := b.ToRunes()
+	ta.Assert(
+		t,
+		reflect.DeepEqual(string(got3[0]), "First line has much text."),
+		"unexpected after first undo: %q",
+		string(got3[0]))
+
+	b.UndoModification()
+	got3 = b.ToRunes()
 	ta.Assert(
 		t,
 		reflect.DeepEqual(got3, msg),
@@ -502,3 +513,572 @@ func TestReplace(t *testing.T) {
 		msg)
 	t.Log(string(got3[0]), string(got3[1]))
 }
+
+func TestRedo(t *testing.T) {
+	msg := [][]rune{
+		[]rune("first"),
+		[]rune("second"),
+	}
+	b := buffer.New(msg)
+
+	b.Perform(buffer.NewLinefeed(0, 2))
+	b.Perform(buffer.NewBackspace(2, 0))
+	b.Perform(buffer.NewDelLineContent(1, 1))
+	b.Replace([]rune("fi"), []rune("FI"))
+	want := b.ToRunes()
+
+	for b.UndoModification() != nil {
+	}
+	got := b.ToRunes()
+	ta.Assert(t, reflect.DeepEqual(got, msg), "want %q after undo, got %q", msg, got)
+
+	for b.RedoModification() != nil {
+	}
+	got = b.ToRunes()
+	ta.Assert(t, reflect.DeepEqual(got, want), "want %q after redo, got %q", want, got)
+	ta.Assert(t, !b.Redoable(), "should not be redoable anymore")
+
+	// A fresh modification should forget everything redoable.
+	b.UndoModification()
+	ta.Assert(t, b.Redoable(), "should be redoable")
+	b.Perform(buffer.NewInsert(0, 0, []rune("x")))
+	ta.Assert(t, !b.Redoable(), "should not be redoable after a modification")
+}
+
+func TestUndoGroups(t *testing.T) {
+	msg := [][]rune{
+		[]rune("first"),
+		[]rune("second"),
+	}
+	b := buffer.New(msg)
+
+	// Backspacing at the beginning of a line consists of multiple
+	// modifications, but it should be undone at once.
+	b.Perform(buffer.NewBackspace(1, 0))
+	b.UndoModification()
+	got := b.ToRunes()
+	ta.Assert(t, reflect.DeepEqual(got, msg), "want %q, got %q", msg, got)
+
+	// Explicit groups are undone at once.
+	b.BeginGroup()
+	b.Perform(buffer.NewInsert(0, 0, []rune("a")))
+	b.Perform(buffer.NewLinefeed(0, 1))
+	b.Perform(buffer.NewDelLineContent(2, 2))
+	b.EndGroup()
+	b.UndoModification()
+	got = b.ToRunes()
+	ta.Assert(t, reflect.DeepEqual(got, msg), "want %q, got %q", msg, got)
+}
+
+func TestUndoTyping(t *testing.T) {
+	defer func(idle time.Duration) { config.UNDO_IDLE = idle }(config.UNDO_IDLE)
+	config.UNDO_IDLE = time.Hour
+
+	b := buffer.New(nil)
+	for i, r := range "some typed words" {
+		b.Perform(buffer.NewInsert(0, i, []rune{r}))
+	}
+
+	wants := []string{"some typed ", "some ", ""}
+	for _, want := range wants {
+		b.UndoModification()
+		got := string(b.GetLine(0))
+		ta.Assert(t, got == want, "want %q, got %q", want, got)
+	}
+
+	// Typing should not be coalesced over idle periods.
+	config.UNDO_IDLE = 0
+	for i, r := range "abc" {
+		b.Perform(buffer.NewInsert(0, i, []rune{r}))
+	}
+	b.UndoModification()
+	got := string(b.GetLine(0))
+	ta.Assert(t, got == "ab", "want %q, got %q", "ab", got)
+}
+
+func TestReload(t *testing.T) {
+	msg := [][]rune{
+		[]rune("first"),
+		[]rune("second"),
+	}
+	b := buffer.New(msg)
+
+	if err := b.Reload(strings.NewReader("reloaded\ncontents\nhere\n")); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]rune{
+		[]rune("reloaded"),
+		[]rune("contents"),
+		[]rune("here"),
+	}
+	got := b.ToRunes()
+	ta.Assert(t, reflect.DeepEqual(got, want), "want %q, got %q", want, got)
+
+	b.UndoModification()
+	got = b.ToRunes()
+	ta.Assert(t, reflect.DeepEqual(got, msg), "want %q, got %q", msg, got)
+
+	b.RedoModification()
+	got = b.ToRunes()
+	ta.Assert(t, reflect.DeepEqual(got, want), "want %q, got %q", want, got)
+}
+
+func TestRegion(t *testing.T) {
+	msg := [][]rune{
+		[]rune("first line"),
+		[]rune("second line"),
+		[]rune("third line"),
+	}
+	b := buffer.New(msg)
+
+	got := b.Region(0, 6, 2, 5)
+	want := [][]rune{
+		[]rune("line"),
+		[]rune("second line"),
+		[]rune("third"),
+	}
+	ta.Assert(t, reflect.DeepEqual(got, want), "want %q, got %q", want, got)
+
+	res := b.Perform(buffer.NewDelRegion(0, 6, 2, 5))
+	ta.Assert(t, res.Lineno == 0 && res.Col == 6, "unexpected result: %+v", res)
+	wantdel := [][]rune{[]rune("first  line")}
+	gotdel := b.ToRunes()
+	ta.Assert(t, reflect.DeepEqual(gotdel, wantdel), "want %q, got %q", wantdel, gotdel)
+
+	// Yank the deleted text back and see that we end up where we
+	// started from.
+	res = b.Perform(buffer.NewYank(0, 6, got))
+	ta.Assert(t, res.Lineno == 2 && res.Col == 5, "unexpected result: %+v", res)
+	gotyank := b.ToRunes()
+	ta.Assert(t, reflect.DeepEqual(gotyank, msg), "want %q, got %q", msg, gotyank)
+
+	// Undo both yank and deletion.
+	b.UndoModification()
+	gotundo := b.ToRunes()
+	ta.Assert(t, reflect.DeepEqual(gotundo, wantdel), "want %q, got %q", wantdel, gotundo)
+	b.UndoModification()
+	gotundo = b.ToRunes()
+	ta.Assert(t, reflect.DeepEqual(gotundo, msg), "want %q, got %q", msg, gotundo)
+
+	// Redo deletion and then do a single-line yank.
+	b.RedoModification()
+	b.Perform(buffer.NewYank(0, 0, [][]rune{[]rune(">> ")}))
+	wantsingle := [][]rune{[]rune(">> first  line")}
+	gotsingle := b.ToRunes()
+	ta.Assert(t, reflect.DeepEqual(gotsingle, wantsingle), "want %q, got %q", wantsingle, gotsingle)
+}
+
+func TestUndoYank(t *testing.T) {
+	b := buffer.New(nil)
+	b.BeginGroup()
+	b.Perform(buffer.NewInsert(0, 0, []rune("x")))
+	b.Perform(buffer.NewYank(0, 1, [][]rune{[]rune("one"), []rune("two")}))
+	res := b.UndoYank()
+	ta.Assert(t, res != nil && res.Lineno == 0 && res.Col == 1, "unexpected result: %+v", res)
+	got := b.ToRunes()
+	want := [][]rune{[]rune("x")}
+	ta.Assert(t, reflect.DeepEqual(got, want), "want %q, got %q", want, got)
+	ta.Assert(t, b.UndoYank() == nil, "only yanks should be undone")
+	b.Perform(buffer.NewYank(0, 1, [][]rune{[]rune("three")}))
+	b.EndGroup()
+
+	got = b.ToRunes()
+	want = [][]rune{[]rune("xthree")}
+	ta.Assert(t, reflect.DeepEqual(got, want), "want %q, got %q", want, got)
+	b.UndoModification()
+	got = b.ToRunes()
+	want = [][]rune{[]rune("")}
+	ta.Assert(t, reflect.DeepEqual(got, want), "want %q, got %q", want, got)
+}
+
+func TestJoinSince(t *testing.T) {
+	b := buffer.New(nil)
+	b.Perform(buffer.NewInsert(0, 0, []rune("a")))
+	checkpoint := b.Checkpoint()
+	b.Perform(buffer.NewInsert(0, 1, []rune("b")))
+	b.Perform(buffer.NewLinefeed(0, 2))
+	b.UndoModification()
+	b.Perform(buffer.NewInsert(0, 2, []rune("c")))
+	got := b.ToRunes()
+	want := [][]rune{[]rune("abc")}
+	ta.Assert(t, reflect.DeepEqual(got, want), "want %q, got %q", want, got)
+
+	b.JoinSince(checkpoint)
+	b.UndoModification()
+	got = b.ToRunes()
+	want = [][]rune{[]rune("a")}
+	ta.Assert(t, reflect.DeepEqual(got, want), "want %q, got %q", want, got)
+}
+
+func bytesof(t *testing.T, b *buffer.Buffer) string {
+	data, err := b.Bytes()
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+	return string(data)
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	type tc struct {
+		data   string
+		format string
+		lines  int
+		saved  string
+	}
+	tcs := []tc{
+		// Empty files are new files, which get a final newline.
+		{"", "LF", 1, "\n"},
+		{"\n", "LF", 1, ""},
+		{"\r\n", "CRLF", 1, ""},
+		{"\xEF\xBB\xBF\n", "LF,BOM", 1, ""},
+		{"one\ntwo\n", "LF", 2, ""},
+		{"one\ntwo", "LF,noeol", 2, ""},
+		{"one\r\ntwo\r\n", "CRLF", 2, ""},
+		{"one\r\ntwo", "CRLF,noeol", 2, ""},
+		{"\xef\xbb\xbfone\ntwo\n", "LF,BOM", 2, ""},
+		{"\xef\xbb\xbf", "LF,BOM", 1, "\xef\xbb\xbf\n"},
+		{"one\n\n", "LF", 2, ""},
+		{"one\n\ntwo", "LF,noeol", 3, ""},
+	}
+	for _, c := range tcs {
+		t.Run(fmt.Sprintf("%q", c.data), func(t *testing.T) {
+			b, err := buffer.NewFromReader(strings.NewReader(c.data))
+			ta.Assert(t, err == nil, "unexpected error: %v", err)
+			got := b.Format.String()
+			ta.Assert(t, got == c.format, "want format %q, got %q", c.format, got)
+			ta.Assert(t, b.Lines() == c.lines, "want %d lines, got %d", c.lines, b.Lines())
+			saved := c.saved
+			if len(saved) == 0 {
+				saved = c.data
+			}
+			data := bytesof(t, b)
+			ta.Assert(t, data == saved, "want %q, got %q", saved, data)
+		})
+	}
+}
+
+func TestFormatConvert(t *testing.T) {
+	b, err := buffer.NewFromReader(strings.NewReader("one\r\ntwo\r\n"))
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+	got := b.ToRunes()
+	want := [][]rune{[]rune("one"), []rune("two")}
+	ta.Assert(t, reflect.DeepEqual(got, want), "want %q, got %q", want, got)
+
+	b.Format.EOL = buffer.EOL_LF
+	got2 := bytesof(t, b)
+	ta.Assert(t, got2 == "one\ntwo\n", "unexpected %q", got2)
+	b.Format.EOL = buffer.EOL_CRLF
+	b.Format.FinalNewline = false
+	got2 = bytesof(t, b)
+	ta.Assert(t, got2 == "one\r\ntwo", "unexpected %q", got2)
+}
+
+func TestFormatMixed(t *testing.T) {
+	b, err := buffer.NewFromReader(strings.NewReader("one\r\ntwo\nthree\r\n"))
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+	ta.Assert(t, b.Format.String() == "CRLF,mixed", "unexpected format %q", b.Format)
+	got := bytesof(t, b)
+	ta.Assert(t, got == "one\r\ntwo\r\nthree\r\n", "unexpected %q", got)
+	fn := filepath.Join(t.TempDir(), "mixed.txt")
+	ta.Assert(t, b.Save(fn) == nil, "save failed")
+	ta.Assert(t, b.Format.String() == "CRLF", "unexpected format after save %q", b.Format)
+
+	b = buffer.New(nil)
+	ta.Assert(t, bytesof(t, b) == "\n", "new buffers should end with a newline")
+	b, err = buffer.NewFromReader(strings.NewReader(""))
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+	b.Perform(buffer.NewInsert(0, 0, []rune("hello")))
+	got = bytesof(t, b)
+	ta.Assert(t, got == "hello\n", "edited empty files should end with a newline, got %q", got)
+}
+
+func TestLongLines(t *testing.T) {
+	// Multibyte runes will be split across the read buffer
+	// boundaries.
+	long := strings.Repeat("aäö€", 100_000)
+	data := "first\n" + long + "\nlast\n"
+	b, err := buffer.NewFromReader(strings.NewReader(data))
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+	ta.Assert(t, b.Lines() == 3, "want 3 lines, got %d", b.Lines())
+	ta.Assert(t, string(b.GetLine(1)) == long, "long line mangled")
+	ta.Assert(t, string(b.GetLine(2)) == "last", "unexpected %q", string(b.GetLine(2)))
+	ta.Assert(t, bytesof(t, b) == data, "round trip failed")
+}
+
+func TestSniff(t *testing.T) {
+	type tc struct {
+		name string
+		data []byte
+		want buffer.Content
+	}
+	tcs := []tc{
+		{"empty", []byte{}, buffer.CONTENT_TEXT},
+		{"utf-8", []byte("hyvää päivää\n"), buffer.CONTENT_TEXT},
+		{"latin-1", []byte("hyv\xe4\xe4 p\xe4iv\xe4\xe4\n"), buffer.CONTENT_LEGACY},
+		{"nul", []byte("abc\x00def"), buffer.CONTENT_BINARY},
+		{"control", []byte("\x01\x02\x03\x04abc"), buffer.CONTENT_BINARY},
+		{"garbage", []byte("\xff\xfe\xfd\xfc\xfb\xfaab"), buffer.CONTENT_BINARY},
+		// Multibyte runes are split by the sniffing limit.
+		{"boundary", []byte(strings.Repeat("ä", buffer.SNIFFSZ)), buffer.CONTENT_TEXT},
+	}
+	for _, c := range tcs {
+		t.Run(c.name, func(t *testing.T) {
+			got := buffer.Sniff(c.data)
+			ta.Assert(t, got == c.want, "want %d, got %d", c.want, got)
+		})
+	}
+}
+
+func TestLegacyEncoding(t *testing.T) {
+	enc, err := buffer.LookupEncoding("ISO-8859-1")
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+	data := "hyv\xe4\xe4 p\xe4iv\xe4\xe4\r\n"
+	b, err := buffer.NewFromReaderEncoding(strings.NewReader(data), enc)
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+	got := string(b.GetLine(0))
+	ta.Assert(t, got == "hyvää päivää", "unexpected %q", got)
+	ta.Assert(t, b.Format.String() == "CRLF,ISO-8859-1", "unexpected format %q", b.Format)
+	ta.Assert(t, bytesof(t, b) == data, "round trip failed")
+
+	b.Perform(buffer.NewInsert(0, 0, []rune("€")))
+	_, err = b.Bytes()
+	ta.Assert(t, err != nil, "want error for unencodable rune")
+
+	_, err = buffer.LookupEncoding("no-such-encoding")
+	ta.Assert(t, err != nil, "want error for unknown encoding")
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, raw := range []string{
+		"LF", "CRLF", "LF,noeol", "CRLF,mixed", "CRLF,BOM,noeol", "LF,ISO-8859-1",
+	} {
+		f, err := buffer.ParseFormat(raw)
+		ta.Assert(t, err == nil, "%q: unexpected error: %v", raw, err)
+		ta.Assert(t, f.String() == raw, "want %q, got %q", raw, f)
+	}
+	for _, raw := range []string{"", "CR", "BOM,LF", "LF,no-such-encoding"} {
+		_, err := buffer.ParseFormat(raw)
+		ta.Assert(t, err != nil, "%q: want error", raw)
+	}
+}
+
+func TestText(t *testing.T) {
+	enc, err := buffer.LookupEncoding("ISO-8859-1")
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+	b, err := buffer.NewFromReaderEncoding(strings.NewReader("one\r\ntwo"), enc)
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+	b.Perform(buffer.NewInsert(0, 0, []rune("€")))
+	got := string(b.Text())
+	ta.Assert(t, got == "€one\ntwo\n", "unexpected %q", got)
+
+	b, err = buffer.NewFromReader(strings.NewReader(got))
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+	ta.Assert(t, b.Lines() == 2 && string(b.GetLine(0)) == "€one", "text does not read back")
+}
+
+func wholebuffer(b *buffer.Buffer) *buffer.SearchLimit {
+	return &buffer.SearchLimit{
+		EndLineno: b.Lines() - 1,
+		EndCol:    b.LineLength(b.Lines() - 1),
+	}
+}
+
+func TestFindRegexp(t *testing.T) {
+	b := buffer.New([][]rune{
+		[]rune("äää first line"),
+		[]rune("second line"),
+		[]rune("third"),
+	})
+	m, err := search.Compile(`line\nsec`, search.Options{Regexp: true})
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+	match, ok := b.Find(m, wholebuffer(b))
+	ta.Assert(t, ok, "should find match")
+	want := buffer.Match{StartLineno: 0, StartCol: 10, EndLineno: 1, EndCol: 3}
+	ta.Assert(t, match == want, "want %+v, got %+v", want, match)
+
+	m, err = search.Compile(`l(i)ne`, search.Options{Regexp: true})
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+	all := b.FindAll(m, wholebuffer(b))
+	ta.Assert(t, len(all) == 2, "want two matches, got %d", len(all))
+	want = buffer.Match{StartLineno: 1, StartCol: 7, EndLineno: 1, EndCol: 11}
+	ta.Assert(t, all[1] == want, "want %+v, got %+v", want, all[1])
+
+	limits := &buffer.SearchLimit{
+		StartLineno: 0,
+		StartCol:    11,
+		EndLineno:   2,
+		EndCol:      5,
+	}
+	match, ok = b.Find(m, limits)
+	ta.Assert(t, ok && match.StartLineno == 1, "unexpected match %+v", match)
+}
+
+func TestFindWholeWordWithin(t *testing.T) {
+	b := buffer.New([][]rune{
+		[]rune("pid id"),
+		[]rune("idx id"),
+	})
+	m, err := search.Compile("id", search.Options{WholeWord: true})
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+
+	// Starting mid-word, the rest of the word is not a whole word.
+	limits := &buffer.SearchLimit{StartLineno: 0, StartCol: 1, EndLineno: 1, EndCol: 6}
+	match, ok := b.Find(m, limits)
+	want := buffer.Match{StartLineno: 0, StartCol: 4, EndLineno: 0, EndCol: 6}
+	ta.Assert(t, ok && match == want, "want %+v, got %+v", want, match)
+
+	// Ending mid-word, the start of the word is not a whole word.
+	limits = &buffer.SearchLimit{StartLineno: 1, StartCol: 0, EndLineno: 1, EndCol: 2}
+	_, ok = b.Find(m, limits)
+	ta.Assert(t, !ok, "should not match the start of a word")
+
+	limits = &buffer.SearchLimit{StartLineno: 0, StartCol: 1, EndLineno: 1, EndCol: 2}
+	all := b.FindAll(m, limits)
+	ta.Assert(t, len(all) == 1, "want one match, got %+v", all)
+	n, _ := b.ReplaceAll(m, "x", limits)
+	ta.Assert(t, n == 1, "want one replacement, got %d", n)
+	ta.Assert(t, string(b.GetLine(0)) == "pid x", "unexpected %q", string(b.GetLine(0)))
+}
+
+func TestFindAnchorsWithin(t *testing.T) {
+	b := buffer.New([][]rune{
+		[]rune("foo foo"),
+		[]rune("foo bar"),
+	})
+	start, err := search.Compile("^foo", search.Options{Regexp: true})
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+	end, err := search.Compile("foo$", search.Options{Regexp: true})
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+
+	// Starting mid-line, ^ does not match at the start of the limits.
+	limits := &buffer.SearchLimit{StartLineno: 0, StartCol: 4, EndLineno: 1, EndCol: 7}
+	match, ok := b.Find(start, limits)
+	want := buffer.Match{StartLineno: 1, StartCol: 0, EndLineno: 1, EndCol: 3}
+	ta.Assert(t, ok && match == want, "want %+v, got %+v", want, match)
+	all := b.FindAll(start, limits)
+	ta.Assert(t, len(all) == 1, "want one match, got %+v", all)
+
+	// Ending mid-line, $ does not match at the end of the limits.
+	limits = &buffer.SearchLimit{StartLineno: 0, StartCol: 0, EndLineno: 1, EndCol: 3}
+	match, ok = b.Find(end, limits)
+	want = buffer.Match{StartLineno: 0, StartCol: 4, EndLineno: 0, EndCol: 7}
+	ta.Assert(t, ok && match == want, "want %+v, got %+v", want, match)
+	all = b.FindAll(end, limits)
+	ta.Assert(t, len(all) == 1, "want one match, got %+v", all)
+
+	// Matches outside the limits are not replaced.
+	limits = &buffer.SearchLimit{StartLineno: 0, StartCol: 1, EndLineno: 0, EndCol: 7}
+	_, ok = b.ReplaceFirst(start, "x", limits)
+	ta.Assert(t, !ok, "should not replace before the limits")
+	n, _ := b.ReplaceAll(start, "x", limits)
+	ta.Assert(t, n == 0, "want no replacements, got %d", n)
+	limits = &buffer.SearchLimit{StartLineno: 0, StartCol: 4, EndLineno: 1, EndCol: 3}
+	n, _ = b.ReplaceAll(end, "x", limits)
+	ta.Assert(t, n == 1, "want one replacement, got %d", n)
+	ta.Assert(t, string(b.GetLine(0)) == "foo x", "unexpected %q", string(b.GetLine(0)))
+	ta.Assert(t, string(b.GetLine(1)) == "foo bar", "unexpected %q", string(b.GetLine(1)))
+}
+
+func TestReplaceAllRegexp(t *testing.T) {
+	msg := [][]rune{
+		[]rune("one=1 two=2"),
+		[]rune("three=3"),
+	}
+	b := buffer.New(msg)
+	m, err := search.Compile(`(\w+)=(\d)`, search.Options{Regexp: true})
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+	n, last := b.ReplaceAll(m, "$2:\n$1", wholebuffer(b))
+	ta.Assert(t, n == 3, "want 3 replacements, got %d", n)
+	want := [][]rune{
+		[]rune("1:"),
+		[]rune("one 2:"),
+		[]rune("two"),
+		[]rune("3:"),
+		[]rune("three"),
+	}
+	got := b.ToRunes()
+	ta.Assert(t, reflect.DeepEqual(got, want), "want %q, got %q", want, got)
+	wantlast := buffer.Match{StartLineno: 3, StartCol: 0, EndLineno: 4, EndCol: 5}
+	ta.Assert(t, last == wantlast, "want %+v, got %+v", wantlast, last)
+
+	b.UndoModification()
+	got = b.ToRunes()
+	ta.Assert(t, reflect.DeepEqual(got, msg), "want %q, got %q", msg, got)
+}
+
+func TestReplaceAllJoinLines(t *testing.T) {
+	b := buffer.New([][]rune{
+		[]rune("a,"),
+		[]rune("b,"),
+		[]rune("c"),
+	})
+	m, err := search.Compile(`,\n`, search.Options{Regexp: true})
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+	n, _ := b.ReplaceAll(m, ", ", wholebuffer(b))
+	ta.Assert(t, n == 2, "want 2 replacements, got %d", n)
+	want := [][]rune{[]rune("a, b, c")}
+	got := b.ToRunes()
+	ta.Assert(t, reflect.DeepEqual(got, want), "want %q, got %q", want, got)
+}
+
+func TestNearest(t *testing.T) {
+	b := buffer.New([][]rune{
+		[]rune("ab ab"),
+		[]rune("none"),
+		[]rune("ab"),
+	})
+	m, err := search.Compile("ab", search.Options{})
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+	all := b.FindAll(m, wholebuffer(b))
+	ta.Assert(t, len(all) == 3, "want three matches, got %d", len(all))
+
+	type tc struct {
+		lineno, col         int
+		inclusive, backward bool
+		want                int
+		wrapped             bool
+	}
+	tcs := []tc{
+		{0, 0, true, false, 0, false},
+		{0, 0, false, false, 1, false},
+		{0, 1, true, false, 1, false},
+		{1, 2, false, false, 2, false},
+		{2, 0, false, false, 0, true},
+		{2, 1, true, false, 0, true},
+		{2, 0, false, true, 1, false},
+		{0, 3, false, true, 0, false},
+		{0, 0, false, true, 2, true},
+	}
+	for _, c := range tcs {
+		got, wrapped := buffer.Nearest(all, c.lineno, c.col, c.inclusive, c.backward)
+		ta.Assert(t, got == c.want && wrapped == c.wrapped,
+			"%+v: got %d, %v", c, got, wrapped)
+	}
+	got, _ := buffer.Nearest(nil, 0, 0, true, false)
+	ta.Assert(t, got == -1, "want -1 without matches, got %d", got)
+}
+
+func TestReplaceFirst(t *testing.T) {
+	b := buffer.New([][]rune{
+		[]rune("x = f(a)"),
+		[]rune("y = f(b)"),
+	})
+	m, err := search.Compile(`f\((\w)\)`, search.Options{Regexp: true})
+	ta.Assert(t, err == nil, "unexpected error: %v", err)
+	limits := &buffer.SearchLimit{
+		StartLineno: 0,
+		StartCol:    5,
+		EndLineno:   1,
+		EndCol:      8,
+	}
+	match, ok := b.ReplaceFirst(m, "g($1, $1)", limits)
+	ta.Assert(t, ok, "should replace")
+	want := buffer.Match{StartLineno: 1, StartCol: 4, EndLineno: 1, EndCol: 11}
+	ta.Assert(t, match == want, "want %+v, got %+v", want, match)
+	got := b.ToRunes()
+	wantlines := [][]rune{[]rune("x = f(a)"), []rune("y = g(b, b)")}
+	ta.Assert(t, reflect.DeepEqual(got, wantlines), "want %q, got %q", wantlines, got)
+
+	b.UndoModification()
+	got = b.ToRunes()
+	wantlines = [][]rune{[]rune("x = f(a)"), []rune("y = f(b)")}
+	ta.Assert(t, reflect.DeepEqual(got, wantlines), "want %q, got %q", wantlines, got)
+}
@@ -0,0 +1,89 @@
+package buffer
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+type Content int
+
+const (
+	CONTENT_TEXT Content = iota
+	CONTENT_LEGACY
+	CONTENT_BINARY
+)
+
+const (
+	SNIFFSZ = 8192
+	// Percentages of control characters and invalid UTF-8 bytes,
+	// respectively, which make us consider data binary.
+	BINARY_CONTROL = 10
+	BINARY_INVALID = 50
+)
+
+var ErrorBinary = errors.New("file seems to be binary")
+
+// Sniff guesses what kind of content data has. NUL bytes, control
+// characters, and lots of invalid UTF-8 in the beginning of data
+// suggest binary content. Otherwise, data which is not valid UTF-8 is
+// assumed to be text in a legacy encoding.
+func Sniff(data []byte) Content {
+	sample := data
+	if len(sample) > SNIFFSZ {
+		sample = sample[:SNIFFSZ]
+	}
+	control, invalid := 0, 0
+	for i := 0; i < len(sample); {
+		if !utf8.FullRune(sample[i:]) && len(sample) < len(data) {
+			// Sample ends in the middle of a sequence.
+			break
+		}
+		r, sz := utf8.DecodeRune(sample[i:])
+		switch {
+		case r == 0:
+			return CONTENT_BINARY
+		case r == utf8.RuneError && sz == 1:
+			invalid++
+		case r < 0x20 && r != '\t' && r != '\n' && r != '\r' &&
+			r != '\f' && r != '\v' && r != '\x1b':
+			control++
+		}
+		i += sz
+	}
+	if control*100 > len(sample)*BINARY_CONTROL ||
+		invalid*100 > len(sample)*BINARY_INVALID {
+		return CONTENT_BINARY
+	}
+	if !utf8.Valid(data) {
+		return CONTENT_LEGACY
+	}
+	return CONTENT_TEXT
+}
+
+// LookupEncoding finds an encoding by its IANA name, for example
+// "ISO-8859-1" or "windows-1252".
+func LookupEncoding(name string) (encoding.Encoding, error) {
+	enc, err := ianaindex.IANA.Encoding(name)
+	if err != nil {
+		return nil, err
+	}
+	if enc == nil {
+		return nil, fmt.Errorf("unsupported encoding: %s", name)
+	}
+	return enc, nil
+}
+
+func encodingname(enc encoding.Encoding) string {
+	// MIME names are the familiar ones.
+	if name, err := ianaindex.MIME.Name(enc); err == nil {
+		return name
+	}
+	if name, err := ianaindex.IANA.Name(enc); err == nil {
+		return name
+	}
+	return fmt.Sprint(enc)
+}
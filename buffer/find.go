@@ -0,0 +1,292 @@
+package buffer
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/susji/ked/search"
+)
+
+// Match is the position of a search match. The end is exclusive.
+type Match struct {
+	StartLineno, StartCol int
+	EndLineno, EndCol     int
+}
+
+// searchtext is the text of the lines within search limits joined by
+// newlines. Whole lines are searched, so that anchors and word
+// boundaries see the text around the limits, and matches outside the
+// limits are dropped. Byte offsets of the text are mapped back into
+// buffer positions.
+type searchtext struct {
+	text       string
+	start, end int
+	lineno0    int
+	linestarts []int
+	lastoff    int
+	lastlineno int
+	lastcol    int
+}
+
+func (b *Buffer) newsearchtext(limits *SearchLimit) *searchtext {
+	st := &searchtext{
+		lineno0:    limits.StartLineno,
+		lastlineno: limits.StartLineno,
+		end:        -1,
+	}
+	endlineno := limits.EndLineno
+	if endlineno > len(b.lines)-1 {
+		endlineno = len(b.lines) - 1
+	}
+	sb := &strings.Builder{}
+	for lineno := limits.StartLineno; lineno <= endlineno; lineno++ {
+		line := b.lines[lineno].Get()
+		if lineno > limits.StartLineno {
+			sb.WriteByte('\n')
+		}
+		st.linestarts = append(st.linestarts, sb.Len())
+		if lineno == limits.StartLineno {
+			st.start = sb.Len() + len(string(line[:clamp(limits.StartCol, len(line))]))
+		}
+		if lineno == limits.EndLineno {
+			st.end = sb.Len() + len(string(line[:clamp(limits.EndCol, len(line))]))
+		}
+		sb.WriteString(string(line))
+	}
+	st.text = sb.String()
+	if st.end < 0 {
+		st.end = len(st.text)
+	}
+	if st.start > st.end {
+		st.start = st.end
+	}
+	return st
+}
+
+func clamp(col, length int) int {
+	if col > length {
+		return length
+	}
+	return col
+}
+
+// findall returns the byte offsets of the matches within limits.
+func (st *searchtext) findall(m *search.Matcher) [][]int {
+	ret := [][]int{}
+	for _, ix := range m.FindAll(st.text) {
+		if ix[0] >= st.start && ix[1] <= st.end {
+			ret = append(ret, ix)
+		}
+	}
+	return ret
+}
+
+// find returns the byte offsets of the first match within limits.
+func (st *searchtext) find(m *search.Matcher) []int {
+	if ix := m.Find(st.text); ix != nil && ix[0] >= st.start && ix[1] <= st.end {
+		return ix
+	}
+	if all := st.findall(m); len(all) > 0 {
+		return all[0]
+	}
+	return nil
+}
+
+// position converts a byte offset of the text into a buffer position.
+// Converting offsets in increasing order is fast even on long lines.
+func (st *searchtext) position(off int) (lineno, col int) {
+	i := sort.Search(len(st.linestarts), func(i int) bool {
+		return st.linestarts[i] > off
+	}) - 1
+	lineno = st.lineno0 + i
+	base := st.linestarts[i]
+	if lineno == st.lastlineno && st.lastoff >= base && st.lastoff <= off {
+		col = st.lastcol + utf8.RuneCountInString(st.text[st.lastoff:off])
+	} else {
+		col = utf8.RuneCountInString(st.text[base:off])
+	}
+	st.lastoff, st.lastlineno, st.lastcol = off, lineno, col
+	return lineno, col
+}
+
+func (st *searchtext) match(ix []int) Match {
+	ret := Match{}
+	ret.StartLineno, ret.StartCol = st.position(ix[0])
+	ret.EndLineno, ret.EndCol = st.position(ix[1])
+	return ret
+}
+
+// Find returns the first match within limits.
+func (b *Buffer) Find(m *search.Matcher, limits *SearchLimit) (Match, bool) {
+	if len(b.lines) == 0 || limits.StartLineno > limits.EndLineno {
+		return Match{}, false
+	}
+	st := b.newsearchtext(limits)
+	ix := st.find(m)
+	if ix == nil {
+		return Match{}, false
+	}
+	return st.match(ix), true
+}
+
+// FindAll returns all matches within limits.
+func (b *Buffer) FindAll(m *search.Matcher, limits *SearchLimit) []Match {
+	if len(b.lines) == 0 || limits.StartLineno > limits.EndLineno {
+		return nil
+	}
+	st := b.newsearchtext(limits)
+	ret := []Match{}
+	for _, ix := range st.findall(m) {
+		ret = append(ret, st.match(ix))
+	}
+	return ret
+}
+
+// before tells whether the match starts before the given position.
+func (m Match) before(lineno, col int) bool {
+	return m.StartLineno < lineno ||
+		(m.StartLineno == lineno && m.StartCol < col)
+}
+
+// Nearest returns the index of the first match starting after the
+// given position, or at it with inclusive. Backward returns the last
+// match starting before the position. If there is no such match, we
+// wrap around the buffer. Matches are assumed to be in order.
+func Nearest(
+	matches []Match, lineno, col int, inclusive, backward bool) (i int, wrapped bool) {
+
+	if len(matches) == 0 {
+		return -1, false
+	}
+	if backward {
+		for i = len(matches) - 1; i >= 0; i-- {
+			if matches[i].before(lineno, col) {
+				return i, false
+			}
+		}
+		return len(matches) - 1, true
+	}
+	for i = 0; i < len(matches); i++ {
+		m := matches[i]
+		at := m.StartLineno == lineno && m.StartCol == col
+		if !m.before(lineno, col) && (inclusive || !at) {
+			return i, false
+		}
+	}
+	return 0, true
+}
+
+// splittext splits text into lines.
+func splittext(text string) [][]rune {
+	ret := [][]rune{}
+	for _, line := range strings.Split(text, "\n") {
+		ret = append(ret, []rune(line))
+	}
+	return ret
+}
+
+// replacetext replaces the text between the given positions with
+// rep and returns the end of the inserted text.
+func (b *Buffer) replacetext(sl, sc, el, ec int, rep [][]rune) (nel, nec int) {
+	if sl != el || sc != ec {
+		removed := b.deletetext(sl, sc, el, ec)
+		b.modify(&modification{
+			kind:   MOD_DELETETEXT,
+			lineno: sl,
+			col:    sc,
+			data:   removed,
+		})
+	}
+	nel, nec = sl, sc
+	if len(rep) > 1 || len(rep[0]) > 0 {
+		nel, nec = b.inserttext(sl, sc, rep)
+		b.modify(&modification{
+			kind:   MOD_INSERTTEXT,
+			lineno: sl,
+			col:    sc,
+			data:   rep,
+		})
+	}
+	return nel, nec
+}
+
+// ReplaceFirst replaces the first match within limits with the
+// expansion of with. We return the position of the replacement.
+func (b *Buffer) ReplaceFirst(
+	m *search.Matcher, with string, limits *SearchLimit) (Match, bool) {
+
+	if len(b.lines) == 0 || limits.StartLineno > limits.EndLineno {
+		return Match{}, false
+	}
+	st := b.newsearchtext(limits)
+	ix := st.find(m)
+	if ix == nil {
+		return Match{}, false
+	}
+	match := st.match(ix)
+	rep := splittext(m.Expand(with, st.text, ix))
+
+	b.BeginGroup()
+	defer b.EndGroup()
+	nel, nec := b.replacetext(
+		match.StartLineno, match.StartCol, match.EndLineno, match.EndCol, rep)
+	return Match{
+		StartLineno: match.StartLineno,
+		StartCol:    match.StartCol,
+		EndLineno:   nel,
+		EndCol:      nec,
+	}, true
+}
+
+// ReplaceAll replaces all matches within limits with the expansion of
+// with. All replacements are undone as one. We return the number of
+// replacements and the position of the last replacement.
+func (b *Buffer) ReplaceAll(
+	m *search.Matcher, with string, limits *SearchLimit) (n int, last Match) {
+
+	if len(b.lines) == 0 || limits.StartLineno > limits.EndLineno {
+		return 0, Match{}
+	}
+	st := b.newsearchtext(limits)
+	all := st.findall(m)
+	if len(all) == 0 {
+		return 0, Match{}
+	}
+	matches := make([]Match, 0, len(all))
+	reps := make([][][]rune, 0, len(all))
+	for _, ix := range all {
+		matches = append(matches, st.match(ix))
+		reps = append(reps, splittext(m.Expand(with, st.text, ix)))
+	}
+
+	b.BeginGroup()
+	defer b.EndGroup()
+	// Replacing moves the text after it. We track how many lines
+	// have been added in total and how much the end line of the
+	// latest replacement has shifted.
+	linedelta := 0
+	prevend := -1
+	coldelta := 0
+	for i, match := range matches {
+		sl, sc := match.StartLineno+linedelta, match.StartCol
+		el, ec := match.EndLineno+linedelta, match.EndCol
+		if match.StartLineno == prevend {
+			sc += coldelta
+		}
+		if match.EndLineno == prevend {
+			ec += coldelta
+		}
+		nel, nec := b.replacetext(sl, sc, el, ec, reps[i])
+		linedelta += (nel - sl) - (match.EndLineno - match.StartLineno)
+		prevend = match.EndLineno
+		coldelta = nec - match.EndCol
+		last = Match{
+			StartLineno: sl,
+			StartCol:    sc,
+			EndLineno:   nel,
+			EndCol:      nec,
+		}
+	}
+	return len(matches), last
+}
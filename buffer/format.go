@@ -0,0 +1,188 @@
+package buffer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/susji/ked/gapbuffer"
+	"golang.org/x/text/encoding"
+)
+
+type LineEnding int
+
+const (
+	EOL_LF LineEnding = iota
+	EOL_CRLF
+)
+
+var BOM = []byte{0xef, 0xbb, 0xbf}
+
+// Format describes how the buffer contents are laid out in a file.
+// Encoding is nil for UTF-8.
+type Format struct {
+	EOL          LineEnding
+	BOM          bool
+	FinalNewline bool
+	Encoding     encoding.Encoding
+	// Mixed tells that the file had both line endings. Saving
+	// normalizes them to EOL.
+	Mixed bool
+}
+
+var DefaultFormat = Format{
+	EOL:          EOL_LF,
+	BOM:          false,
+	FinalNewline: true,
+}
+
+func (le LineEnding) String() string {
+	switch le {
+	case EOL_CRLF:
+		return "CRLF"
+	default:
+		return "LF"
+	}
+}
+
+func (le LineEnding) bytes() []byte {
+	switch le {
+	case EOL_CRLF:
+		return []byte("\r\n")
+	default:
+		return []byte("\n")
+	}
+}
+
+func (f Format) String() string {
+	ret := []string{f.EOL.String()}
+	if f.BOM {
+		ret = append(ret, "BOM")
+	}
+	if f.Mixed {
+		ret = append(ret, "mixed")
+	}
+	if !f.FinalNewline {
+		ret = append(ret, "noeol")
+	}
+	if f.Encoding != nil {
+		ret = append(ret, encodingname(f.Encoding))
+	}
+	return strings.Join(ret, ",")
+}
+
+// ParseFormat parses the Format given by Format.String.
+func ParseFormat(raw string) (Format, error) {
+	ret := DefaultFormat
+	for i, part := range strings.Split(raw, ",") {
+		switch {
+		case i == 0 && part == "LF":
+			ret.EOL = EOL_LF
+		case i == 0 && part == "CRLF":
+			ret.EOL = EOL_CRLF
+		case i == 0:
+			return ret, fmt.Errorf("invalid line ending: %q", part)
+		case part == "BOM":
+			ret.BOM = true
+		case part == "mixed":
+			ret.Mixed = true
+		case part == "noeol":
+			ret.FinalNewline = false
+		default:
+			enc, err := LookupEncoding(part)
+			if err != nil {
+				return ret, err
+			}
+			ret.Encoding = enc
+		}
+	}
+	return ret, nil
+}
+
+// readlines splits r into lines and detects their format. Files with
+// mixed line endings get the more common one. Empty input is given
+// the default format, so new files are written with a final newline.
+func readlines(r io.Reader) ([]*gapbuffer.GapBuffer, Format, error) {
+	lines := []*gapbuffer.GapBuffer{}
+	format := DefaultFormat
+	br := bufio.NewReader(r)
+	first := true
+	ncrlf, nlf := 0, 0
+	for {
+		line, err := readline(br)
+		eof := errors.Is(err, io.EOF)
+		if err != nil && !eof {
+			return nil, format, err
+		}
+		if first {
+			first = false
+			if len(line) > 0 && line[0] == '\ufeff' {
+				format.BOM = true
+				line = line[1:]
+			}
+		}
+		if eof && len(line) == 0 {
+			// Nothing follows the final newline.
+			break
+		}
+		n := len(line)
+		switch {
+		case n >= 2 && line[n-2] == '\r' && line[n-1] == '\n':
+			ncrlf++
+			line = line[:n-2]
+		case n >= 1 && line[n-1] == '\n':
+			nlf++
+			line = line[:n-1]
+		default:
+			format.FinalNewline = false
+		}
+		lines = append(lines, gapbuffer.NewFrom(line))
+		if eof {
+			break
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, gapbuffer.New(gapbuffer.DEFAULTSZ))
+	}
+	if ncrlf > nlf {
+		format.EOL = EOL_CRLF
+	}
+	format.Mixed = ncrlf > 0 && nlf > 0
+	return lines, format, nil
+}
+
+// readline reads runes up to and including the next newline. Lines
+// longer than the read buffer are decoded chunk by chunk, so their
+// size is not limited and we avoid holding several copies of them.
+func readline(br *bufio.Reader) ([]rune, error) {
+	chunk, err := br.ReadSlice('\n')
+	if !errors.Is(err, bufio.ErrBufferFull) {
+		return []rune(string(chunk)), err
+	}
+	line := []rune{}
+	var pending []byte
+	for {
+		if len(pending) > 0 {
+			chunk = append(pending, chunk...)
+			pending = nil
+		}
+		for len(chunk) > 0 {
+			if !utf8.FullRune(chunk) && errors.Is(err, bufio.ErrBufferFull) {
+				// The rest of the sequence is in the
+				// next chunk.
+				pending = append([]byte{}, chunk...)
+				break
+			}
+			r, sz := utf8.DecodeRune(chunk)
+			line = append(line, r)
+			chunk = chunk[sz:]
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return line, err
+		}
+		chunk, err = br.ReadSlice('\n')
+	}
+}
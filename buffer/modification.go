@@ -1,6 +1,9 @@
 package buffer
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 const (
 	MOD_INSERTRUNES = iota
@@ -8,18 +11,20 @@ const (
 	MOD_DELETERUNES
 	MOD_DELETELINE
 	MOD_MOVERUNES
-	MOD_REPLACERUNES
-	MOD_BREAKPOINT
+	MOD_RELOAD
+	MOD_INSERTTEXT
+	MOD_DELETETEXT
 )
 
 var kindnames = map[modificationKind]string{
-	MOD_INSERTRUNES:  "MOD_INSERTRUNES",
-	MOD_LINEFEED:     "MOD_LINEFEED",
-	MOD_DELETERUNES:  "MOD_DELETERUNES",
-	MOD_DELETELINE:   "MOD_DELETELINE",
-	MOD_MOVERUNES:    "MOD_MOVERUNES",
-	MOD_REPLACERUNES: "MOD_REPLACERUNES",
-	MOD_BREAKPOINT:   "MOD_BREAKPOINT",
+	MOD_INSERTRUNES: "MOD_INSERTRUNES",
+	MOD_LINEFEED:    "MOD_LINEFEED",
+	MOD_DELETERUNES: "MOD_DELETERUNES",
+	MOD_DELETELINE:  "MOD_DELETELINE",
+	MOD_MOVERUNES:   "MOD_MOVERUNES",
+	MOD_RELOAD:      "MOD_RELOAD",
+	MOD_INSERTTEXT:  "MOD_INSERTTEXT",
+	MOD_DELETETEXT:  "MOD_DELETETEXT",
 }
 
 type modificationKind int
@@ -28,14 +33,18 @@ type modification struct {
 	kind        modificationKind
 	lineno, col int
 	data        interface{}
+	// group identifies the undo group this modification belongs
+	// to. All modifications of a group are undone together.
+	group uint64
+	when  time.Time
 }
 
 func (m *modification) String() string {
 	return fmt.Sprintf(
-		"Modification{kind=%s, position=(%d, %d), data=%v}",
-		kindnames[m.kind], m.lineno, m.col, m.data)
+		"Modification{kind=%s, group=%d, position=(%d, %d), data=%v}",
+		kindnames[m.kind], m.group, m.lineno, m.col, m.data)
 }
 
-type replacedata struct {
-	from, to []rune
+type reloaddata struct {
+	from, to [][]rune
 }
@@ -0,0 +1,181 @@
+// package clipboard exchanges text with the clipboard of the host
+// system. This is done either by asking the terminal to set the
+// clipboard with the OSC 52 escape sequence or by running external
+// helper programs such as xclip, wl-copy, or pbcopy.
+package clipboard
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrorNoPaste = errors.New("clipboard does not support pasting")
+)
+
+type Clipboard interface {
+	Copy(text string) error
+	Paste() (string, error)
+}
+
+type osc52 struct {
+	w           io.Writer
+	passthrough bool
+}
+
+// NewOSC52 returns a copy-only Clipboard, which writes the OSC 52
+// escape sequence to w. If passthrough is set, the sequence is wrapped
+// so that tmux passes it to the outer terminal as-is.
+func NewOSC52(w io.Writer, passthrough bool) Clipboard {
+	return &osc52{w: w, passthrough: passthrough}
+}
+
+func (o *osc52) Copy(text string) error {
+	seq := fmt.Sprintf(
+		"\x1b]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(text)))
+	if o.passthrough {
+		seq = "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+	}
+	_, err := io.WriteString(o.w, seq)
+	return err
+}
+
+func (o *osc52) Paste() (string, error) {
+	return "", ErrorNoPaste
+}
+
+type command struct {
+	copycmd, pastecmd []string
+}
+
+// NewCommand returns a Clipboard, which runs copycmd with the copied
+// text as its standard input and uses the standard output of pastecmd
+// as the pasted text. Either of the commands may be empty.
+func NewCommand(copycmd, pastecmd []string) Clipboard {
+	return &command{copycmd: copycmd, pastecmd: pastecmd}
+}
+
+func (c *command) Copy(text string) error {
+	if len(c.copycmd) == 0 {
+		return nil
+	}
+	cmd := exec.Command(c.copycmd[0], c.copycmd[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v: %s", c.copycmd[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
+
+func (c *command) Paste() (string, error) {
+	if len(c.pastecmd) == 0 {
+		return "", ErrorNoPaste
+	}
+	cmd := exec.Command(c.pastecmd[0], c.pastecmd[1:]...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %v: %s", c.pastecmd[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return string(out), nil
+}
+
+type async struct {
+	cb     Clipboard
+	onerr  func(error)
+	latest uint64
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+}
+
+// NewAsync returns a Clipboard, which copies with cb in the background
+// so that slow helper programs do not block the caller. Of the copies
+// waiting for their turn, only the latest is done. onerr is called with
+// the errors of the copies. Pasting waits for the pending copies.
+func NewAsync(cb Clipboard, onerr func(error)) Clipboard {
+	return &async{cb: cb, onerr: onerr}
+}
+
+func (a *async) Copy(text string) error {
+	id := atomic.AddUint64(&a.latest, 1)
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if atomic.LoadUint64(&a.latest) != id {
+			return
+		}
+		if err := a.cb.Copy(text); err != nil {
+			a.onerr(err)
+		}
+	}()
+	return nil
+}
+
+func (a *async) Paste() (string, error) {
+	a.wg.Wait()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cb.Paste()
+}
+
+type multi []Clipboard
+
+// NewMulti returns a Clipboard, which copies into all of the given
+// clipboards and pastes from the first one supporting pasting.
+func NewMulti(cbs ...Clipboard) Clipboard {
+	return multi(cbs)
+}
+
+func (m multi) Copy(text string) error {
+	var reterr error
+	for _, cb := range m {
+		if err := cb.Copy(text); err != nil && reterr == nil {
+			reterr = err
+		}
+	}
+	return reterr
+}
+
+func (m multi) Paste() (string, error) {
+	for _, cb := range m {
+		text, err := cb.Paste()
+		if errors.Is(err, ErrorNoPaste) {
+			continue
+		}
+		return text, err
+	}
+	return "", ErrorNoPaste
+}
+
+type tty struct {
+	path string
+}
+
+// NewTTY returns a writer, which writes directly into the controlling
+// terminal. This way escape sequences bypass the screen handling of
+// the editor.
+func NewTTY() io.Writer {
+	return &tty{path: "/dev/tty"}
+}
+
+func (t *tty) Write(p []byte) (int, error) {
+	f, err := os.OpenFile(t.path, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.Write(p)
+}
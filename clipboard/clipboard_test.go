@@ -0,0 +1,116 @@
+package clipboard_test
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/susji/ked/clipboard"
+	tu "github.com/susji/ked/internal/testutil"
+)
+
+func TestOSC52(t *testing.T) {
+	b := &bytes.Buffer{}
+	cb := clipboard.NewOSC52(b, false)
+	if err := cb.Copy("hello"); err != nil {
+		t.Fatal(err)
+	}
+	want := "\x1b]52;c;aGVsbG8=\a"
+	tu.Assert(t, b.String() == want, "want %q, got %q", want, b.String())
+
+	_, err := cb.Paste()
+	tu.Assert(t, errors.Is(err, clipboard.ErrorNoPaste), "unexpected paste error: %v", err)
+}
+
+func TestOSC52Passthrough(t *testing.T) {
+	b := &bytes.Buffer{}
+	cb := clipboard.NewOSC52(b, true)
+	if err := cb.Copy("hello"); err != nil {
+		t.Fatal(err)
+	}
+	want := "\x1bPtmux;\x1b\x1b]52;c;aGVsbG8=\a\x1b\\"
+	tu.Assert(t, b.String() == want, "want %q, got %q", want, b.String())
+}
+
+func TestCommand(t *testing.T) {
+	cb := clipboard.NewCommand([]string{"true"}, []string{"echo", "pasted"})
+	if err := cb.Copy("ignored"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := cb.Paste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tu.Assert(t, got == "pasted\n", "unexpected paste: %q", got)
+
+	cb = clipboard.NewCommand([]string{"false"}, nil)
+	tu.Assert(t, cb.Copy("fails") != nil, "copy should fail")
+	_, err = cb.Paste()
+	tu.Assert(t, errors.Is(err, clipboard.ErrorNoPaste), "unexpected paste error: %v", err)
+}
+
+func TestMulti(t *testing.T) {
+	b := &bytes.Buffer{}
+	cb := clipboard.NewMulti(
+		clipboard.NewOSC52(b, false),
+		clipboard.NewCommand(nil, []string{"echo", "-n", "second"}))
+	if err := cb.Copy("x"); err != nil {
+		t.Fatal(err)
+	}
+	tu.Assert(t, b.Len() > 0, "osc52 should have been written")
+	got, err := cb.Paste()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tu.Assert(t, got == "second", "unexpected paste: %q", got)
+}
+
+type slow struct {
+	mu     sync.Mutex
+	copied []string
+}
+
+func (s *slow) Copy(text string) error {
+	time.Sleep(50 * time.Millisecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.copied = append(s.copied, text)
+	if text == "fail" {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func (s *slow) Paste() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.copied[len(s.copied)-1], nil
+}
+
+func TestAsync(t *testing.T) {
+	errs := make(chan error, 10)
+	s := &slow{}
+	cb := clipboard.NewAsync(s, func(err error) { errs <- err })
+
+	start := time.Now()
+	for _, text := range []string{"one", "two", "three"} {
+		tu.Assert(t, cb.Copy(text) == nil, "copy should not fail")
+	}
+	tu.Assert(t, time.Since(start) < 50*time.Millisecond, "copying should not block")
+
+	got, err := cb.Paste()
+	tu.Assert(t, err == nil, "unexpected paste error: %v", err)
+	tu.Assert(t, got == "three", "latest copy should win, got %q", got)
+	tu.Assert(t, len(s.copied) <= 2, "superseded copies should be skipped: %q", s.copied)
+
+	cb.Copy("fail")
+	cb.Paste()
+	select {
+	case err := <-errs:
+		tu.Assert(t, err != nil, "should report the error")
+	default:
+		t.Error("failed copy was not reported")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/susji/ked/buffer"
 	"github.com/susji/ked/config"
 	"github.com/susji/ked/ui/editor"
 )
@@ -65,6 +66,13 @@ func main() {
 	// Initial editor context consists of a canvas and an optional
 	// list file-backed buffers.
 	e := editor.New()
+	e.EnableRecovery(config.STATEDIR)
+	e.EnableHistory(config.HISTORYFILE)
+	e.EnableMacros(config.MACROFILE)
+	if err := e.BindKeys(config.KEYS); err != nil {
+		fmt.Fprintf(os.Stderr, "ked: %v\n", err)
+		os.Exit(1)
+	}
 	filenames := flag.Args()
 	for _, filename := range filenames {
 		absname, err := filepath.Abs(filename)
@@ -74,8 +82,12 @@ func main() {
 		f, err := os.Open(absname)
 		if err == nil {
 			log.Println("opening buffer for file: ", filename)
-			e.NewBuffer(absname, f)
+			_, err := e.OpenFile(absname, f)
 			f.Close()
+			if errors.Is(err, buffer.ErrorBinary) {
+				fmt.Fprintf(os.Stderr, "ked: %s: %v\n", filename, err)
+				os.Exit(1)
+			}
 		} else if errors.Is(err, os.ErrNotExist) {
 			e.NewBuffer(absname, &bytes.Buffer{})
 		} else {
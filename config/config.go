@@ -5,8 +5,10 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/susji/ked/util"
@@ -48,10 +50,43 @@ const (
 )
 
 var STYLE_DEFAULT = tcell.StyleDefault
+
+// STYLE_MATCH is added to search matches and STYLE_CURRENT_MATCH to the
+// match at the cursor.
+var STYLE_MATCH = tcell.StyleDefault.Underline(true)
+var STYLE_CURRENT_MATCH = tcell.StyleDefault.Reverse(true).Underline(true)
 var CONFFILES = getConfigFiles()
+
+// CONFFILE is the configuration file in use, if any was found.
+var CONFFILE string
 var WARNFILESZ = int64(10_485_760)
+var UNDO_IDLE = time.Second
+var DISKCHECK = time.Duration(0)
+var AUTOSAVE = 30 * time.Second
+var STATEDIR = getStateDir()
+var HISTORYFILE = getHistoryFile()
+var MACROFILE = getMacroFile()
+var CHORDTIMEOUT = 3 * time.Second
+
+// KeyBinding binds the key given by Key to a named editor command. It
+// comes from line Lineno of the configuration file Fn.
+type KeyBinding struct {
+	Key, Command string
+	Fn           string
+	Lineno       int
+}
+
+// KEYS override the default key bindings in the order they are given.
+var KEYS []KeyBinding
 var MAXFILES = 50_000
+var MAXHIGHLIGHTLEN = 10_000
 var WORD_DELIMS = " \t=&|,./(){}[]#+*%'-:?!'\""
+var LEGACY_ENCODING = "ISO-8859-1"
+var PERSIST_MATCHES = false
+var CLIPBOARD_OSC52 = false
+var CLIPBOARD_PASSTHROUGH = false
+var CLIPBOARD_COPY []string
+var CLIPBOARD_PASTE []string
 var IGNOREDIRS = map[string]bool{
 	".git":         true,
 	"node_modules": true,
@@ -74,7 +109,7 @@ func GetIgnoreDirsFlat() string {
 	return strings.Join(ret, ",")
 }
 
-func splitsavehook(raw string) []string {
+func splitcommand(raw string) []string {
 	return regexp.MustCompile(" +").Split(raw, -1)
 }
 
@@ -134,6 +169,7 @@ func HandleConfigFile() {
 			}
 		}
 		log.Println("Got config:", c)
+		CONFFILE = f.Name()
 		ParseConfig(f.Name(), c)
 		return
 	}
@@ -193,11 +229,114 @@ func ParseConfig(fn string, c map[string]tinyini.Section) {
 		}
 
 		if savehooks, ok := g["savehook"]; ok {
-			sh := splitsavehook(savehooks[0].Value)
+			sh := splitcommand(savehooks[0].Value)
 			editorconfigs[""].SaveHook = sh
 			log.Println("global savehook:", sh)
 		}
 
+		if diskchecks, ok := g["diskcheck"]; ok {
+			kv := diskchecks[0]
+			if diskcheck, err := strconv.Atoi(kv.Value); err != nil {
+				log.Printf("%s:%d: invalid diskcheck: %v\n", fn, kv.Lineno, err)
+			} else {
+				DISKCHECK = time.Duration(diskcheck) * time.Second
+				log.Println("DISKCHECK", DISKCHECK)
+			}
+		}
+
+		if legacyencodings, ok := g["legacyencoding"]; ok {
+			LEGACY_ENCODING = legacyencodings[0].Value
+			log.Println("LEGACY_ENCODING", LEGACY_ENCODING)
+		}
+
+		if autosaves, ok := g["autosave"]; ok {
+			kv := autosaves[0]
+			if autosave, err := strconv.Atoi(kv.Value); err != nil {
+				log.Printf("%s:%d: invalid autosave: %v\n", fn, kv.Lineno, err)
+			} else {
+				AUTOSAVE = time.Duration(autosave) * time.Second
+				log.Println("AUTOSAVE", AUTOSAVE)
+			}
+		}
+
+		if chordtimeouts, ok := g["chordtimeout"]; ok {
+			kv := chordtimeouts[0]
+			if chordtimeout, err := strconv.Atoi(kv.Value); err != nil {
+				log.Printf("%s:%d: invalid chordtimeout: %v\n", fn, kv.Lineno, err)
+			} else {
+				CHORDTIMEOUT = time.Duration(chordtimeout) * time.Second
+				log.Println("CHORDTIMEOUT", CHORDTIMEOUT)
+			}
+		}
+
+		if statedirs, ok := g["statedir"]; ok {
+			STATEDIR = statedirs[0].Value
+			log.Println("STATEDIR", STATEDIR)
+		}
+
+		if historyfiles, ok := g["historyfile"]; ok {
+			HISTORYFILE = historyfiles[0].Value
+			log.Println("HISTORYFILE", HISTORYFILE)
+		}
+
+		if macrofiles, ok := g["macrofile"]; ok {
+			MACROFILE = macrofiles[0].Value
+			log.Println("MACROFILE", MACROFILE)
+		}
+
+		if persist, ok := g["persistmatches"]; ok {
+			PERSIST_MATCHES = confbool(persist[0].Value)
+			log.Println("PERSIST_MATCHES", PERSIST_MATCHES)
+		}
+
+		if matchstyles, ok := g["matchstyle"]; ok {
+			STYLE_MATCH = parsestyle(matchstyles[0].Value)
+			log.Println("STYLE_MATCH", STYLE_MATCH)
+		}
+
+		if currentstyles, ok := g["currentmatchstyle"]; ok {
+			STYLE_CURRENT_MATCH = parsestyle(currentstyles[0].Value)
+			log.Println("STYLE_CURRENT_MATCH", STYLE_CURRENT_MATCH)
+		}
+
+		if osc52, ok := g["clipboard-osc52"]; ok {
+			CLIPBOARD_OSC52 = confbool(osc52[0].Value)
+			log.Println("CLIPBOARD_OSC52", CLIPBOARD_OSC52)
+		}
+
+		if passthrough, ok := g["clipboard-passthrough"]; ok {
+			CLIPBOARD_PASSTHROUGH = confbool(passthrough[0].Value)
+			log.Println("CLIPBOARD_PASSTHROUGH", CLIPBOARD_PASSTHROUGH)
+		}
+
+		if copycmd, ok := g["clipboard-copy"]; ok {
+			CLIPBOARD_COPY = splitcommand(copycmd[0].Value)
+			log.Println("CLIPBOARD_COPY", CLIPBOARD_COPY)
+		}
+
+		if pastecmd, ok := g["clipboard-paste"]; ok {
+			CLIPBOARD_PASTE = splitcommand(pastecmd[0].Value)
+			log.Println("CLIPBOARD_PASTE", CLIPBOARD_PASTE)
+		}
+
+	}
+
+	if keyvals, ok := c["keys"]; ok {
+		KEYS = nil
+		for key, commands := range keyvals {
+			for _, kv := range commands {
+				KEYS = append(KEYS, KeyBinding{
+					Key:     key,
+					Command: kv.Value,
+					Fn:      fn,
+					Lineno:  kv.Lineno,
+				})
+			}
+		}
+		sort.Slice(KEYS, func(i, j int) bool {
+			return KEYS[i].Lineno < KEYS[j].Lineno
+		})
+		log.Println("KEYS", KEYS)
 	}
 
 	// Handle filetype-related sections.
@@ -217,7 +356,7 @@ func ParseConfig(fn string, c map[string]tinyini.Section) {
 		log.Println("keyvals", keyvals)
 
 		if savehooks, ok := keyvals["savehook"]; ok {
-			sh := splitsavehook(savehooks[0].Value)
+			sh := splitcommand(savehooks[0].Value)
 			editorconfigs[pattern].SaveHook = sh
 			log.Println(pattern, "savehook:", sh)
 		}
@@ -315,6 +454,38 @@ func getConfigFiles() (files []string) {
 	return
 }
 
+// getStateDir follows the XDG Base Directory Specification for the
+// location of recovery journals.
+func getStateDir() string {
+	if statedir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(statedir) {
+		return filepath.Join(statedir, "ked")
+	}
+	if homedir, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(homedir, ".local", "state", "ked")
+	}
+	log.Println("Cannot determine state directory")
+	return ""
+}
+
+// getHistoryFile returns the file for persisting the answers given to
+// prompts.
+func getHistoryFile() string {
+	if confdir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(confdir, "ked", "history")
+	}
+	log.Println("Cannot determine history file")
+	return ""
+}
+
+// getMacroFile returns the file for persisting the named macros.
+func getMacroFile() string {
+	if confdir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(confdir, "ked", "macros")
+	}
+	log.Println("Cannot determine macro file")
+	return ""
+}
+
 func GetEditorConfig(fpath string) *EditorConfig {
 	pb := filepath.Base(fpath)
 	log.Println("[GetEditorConfig] ", fpath, " -> ", pb)
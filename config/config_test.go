@@ -3,6 +3,7 @@ package config_test
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/susji/ked/config"
@@ -96,3 +97,77 @@ func TestConfigSection(t *testing.T) {
 		"unexpected highlight patterns: %#v",
 		ec.HighlightPatterns)
 }
+
+func TestConfigClipboard(t *testing.T) {
+	c := map[string]ti.Section{
+		"": ti.Section{
+			"clipboard-osc52":       []ti.Pair{ti.Pair{Value: "yes", Lineno: 1}},
+			"clipboard-passthrough": []ti.Pair{ti.Pair{Value: "true", Lineno: 2}},
+			"clipboard-copy":        []ti.Pair{ti.Pair{Value: "xclip -selection clipboard", Lineno: 3}},
+			"clipboard-paste":       []ti.Pair{ti.Pair{Value: "xclip -selection clipboard -o", Lineno: 4}},
+		},
+	}
+
+	config.ParseConfig("test.ini", c)
+
+	tu.Assert(t, config.CLIPBOARD_OSC52, "osc52 should be enabled")
+	tu.Assert(t, config.CLIPBOARD_PASSTHROUGH, "passthrough should be enabled")
+	tu.Assert(
+		t,
+		reflect.DeepEqual(config.CLIPBOARD_COPY, []string{"xclip", "-selection", "clipboard"}),
+		"unexpected copy command: %#v",
+		config.CLIPBOARD_COPY)
+	tu.Assert(
+		t,
+		reflect.DeepEqual(config.CLIPBOARD_PASTE, []string{"xclip", "-selection", "clipboard", "-o"}),
+		"unexpected paste command: %#v",
+		config.CLIPBOARD_PASTE)
+}
+
+func TestConfigKeys(t *testing.T) {
+	c := map[string]ti.Section{
+		"": ti.Section{
+			"chordtimeout": []ti.Pair{ti.Pair{Value: "5", Lineno: 1}},
+		},
+		"keys": ti.Section{
+			"Ctrl+O":        []ti.Pair{ti.Pair{Value: "open-file", Lineno: 3}},
+			"Ctrl+X Ctrl+S": []ti.Pair{ti.Pair{Value: "save", Lineno: 5}},
+			"Ctrl+F": []ti.Pair{
+				ti.Pair{Value: "search", Lineno: 2},
+				ti.Pair{Value: "none", Lineno: 4}},
+		},
+	}
+
+	config.ParseConfig("test.ini", c)
+
+	want := []config.KeyBinding{
+		{Key: "Ctrl+F", Command: "search", Fn: "test.ini", Lineno: 2},
+		{Key: "Ctrl+O", Command: "open-file", Fn: "test.ini", Lineno: 3},
+		{Key: "Ctrl+F", Command: "none", Fn: "test.ini", Lineno: 4},
+		{Key: "Ctrl+X Ctrl+S", Command: "save", Fn: "test.ini", Lineno: 5},
+	}
+	tu.Assert(t, reflect.DeepEqual(config.KEYS, want), "unexpected keys: %#v", config.KEYS)
+	tu.Assert(t, config.CHORDTIMEOUT == 5*time.Second, "unexpected chord timeout: %v", config.CHORDTIMEOUT)
+}
+
+func TestConfigMatchStyles(t *testing.T) {
+	c := map[string]ti.Section{
+		"": ti.Section{
+			"matchstyle":        []ti.Pair{ti.Pair{Value: "bold", Lineno: 1}},
+			"currentmatchstyle": []ti.Pair{ti.Pair{Value: "bold,reverse", Lineno: 2}},
+		},
+	}
+
+	config.ParseConfig("test.ini", c)
+
+	tu.Assert(
+		t,
+		config.STYLE_MATCH == tcell.StyleDefault.Bold(true),
+		"unexpected match style: %v",
+		config.STYLE_MATCH)
+	tu.Assert(
+		t,
+		config.STYLE_CURRENT_MATCH == tcell.StyleDefault.Bold(true).Reverse(true),
+		"unexpected current match style: %v",
+		config.STYLE_CURRENT_MATCH)
+}
@@ -1,8 +1,6 @@
 package gapbuffer
 
 import (
-	"bytes"
-	"encoding/hex"
 	"fmt"
 )
 
@@ -34,13 +32,6 @@ func NewFrom(runes []rune) *GapBuffer {
 	return gb
 }
 
-func hexdump(what []rune) {
-	b := &bytes.Buffer{}
-	d := hex.Dumper(b)
-	d.Write([]byte(string(what)))
-	//log.Println("\n" + b.String())
-}
-
 func debug(f string, va ...interface{}) {
 	//log.Printf(f+"\n", va...)
 }
@@ -49,50 +40,30 @@ func (gb *GapBuffer) Cursor() int {
 	return gb.pre
 }
 
-func (gb *GapBuffer) cursorprev() {
-	// |abcdefghijklmnopq/    /rstu|
-	// |abcdefghijklmnop/    /qrstu|
-	if gb.pre == 0 {
-		panic("cursorprev: pre == 0")
-	}
-	gb.pre--
-	gb.post--
-	gb.buf[gb.post] = gb.buf[gb.pre]
-}
-
-func (gb *GapBuffer) cursornext() {
-	// |abcdefghijklmnop/    /qrstu|
-	// |abcdefghijklmnopq/    /rstu|
-	if gb.post+1 > len(gb.buf) {
-		panic("cursornext: post + 1 > len(buf)")
-	}
-	gb.buf[gb.pre] = gb.buf[gb.post]
-	gb.pre++
-	gb.post++
-}
-
 func (gb *GapBuffer) SetCursor(cursor int) *GapBuffer {
 	debug("(SetCursor before) pre=%d  post=%d  {%d <- %d}", gb.pre, gb.post, cursor, gb.pre)
 	if cursor > gb.Length()+1 {
 		panic("cursor > gb.Length")
 	}
-	newpre := cursor
-	var moves int
-	var f func()
-	if newpre < gb.pre {
-		f = gb.cursorprev
-		moves = gb.pre - newpre
+	// Moving the cursor means moving the runes between the old
+	// and the new cursor to the other side of the gap. This is
+	// done as one block so that long lines stay fast.
+	if cursor < gb.pre {
+		// |abcdefghijklmnopq/    /rstu|
+		// |abcdefghijklm/    /nopqrstu|
+		n := gb.pre - cursor
+		copy(gb.buf[gb.post-n:gb.post], gb.buf[cursor:gb.pre])
+		gb.pre -= n
+		gb.post -= n
 	} else {
-		f = gb.cursornext
-		moves = newpre - gb.pre
-	}
-
-	for i := 0; i < moves; i++ {
-		f()
-		hexdump(gb.buf)
+		// |abcdefghijklm/    /nopqrstu|
+		// |abcdefghijklmnopq/    /rstu|
+		n := cursor - gb.pre
+		copy(gb.buf[gb.pre:gb.pre+n], gb.buf[gb.post:gb.post+n])
+		gb.pre += n
+		gb.post += n
 	}
 	debug("(SetCursor afterwards) pre=%d  post=%d", gb.pre, gb.post)
-	hexdump(gb.buf)
 	return gb
 }
 
@@ -118,6 +89,11 @@ func (gb *GapBuffer) gapgrow(atleast int) {
 	if atleast < GAP_MIN_INCREASE {
 		atleast = GAP_MIN_INCREASE
 	}
+	// Grow long lines proportionally to avoid copying them over
+	// and over again when typing.
+	if atleast < len(gb.buf)/8 {
+		atleast = len(gb.buf) / 8
+	}
 
 	n := len(gb.buf) - gb.post
 	gb.buf = append(gb.buf, make([]rune, atleast)...)
@@ -146,7 +122,6 @@ func (gb *GapBuffer) Insert(what []rune) *GapBuffer {
 	}
 	copy(gb.buf[gb.pre:], what)
 	gb.pre += len(what)
-	hexdump(gb.buf)
 	return gb
 }
 
@@ -5,6 +5,7 @@ go 1.17
 require (
 	github.com/gdamore/tcell/v2 v2.4.0
 	github.com/susji/tinyini v0.4.0
+	golang.org/x/text v0.3.7
 )
 
 require (
@@ -14,5 +15,4 @@ require (
 	github.com/rivo/uniseg v0.2.0 // indirect
 	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect
 	golang.org/x/term v0.0.0-20210927222741-03fcf44c2211 // indirect
-	golang.org/x/text v0.3.7 // indirect
 )
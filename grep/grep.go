@@ -0,0 +1,176 @@
+// package grep searches for matches within many files at once.
+package grep
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/susji/ked/buffer"
+	"github.com/susji/ked/config"
+	"github.com/susji/ked/search"
+)
+
+// MAXRESULTS limits the amount of results we collect.
+const MAXRESULTS = 10_000
+
+// Result is a matching line. Lineno and Col are zero-based and point
+// to the beginning of the first match on the line, and Matches is the
+// number of matches on the line.
+type Result struct {
+	Filepath string
+	Lineno   int
+	Col      int
+	Line     string
+	Matches  int
+}
+
+// Files searches the given files concurrently line by line. Files
+// larger than config.WARNFILESZ and files which seem binary are
+// skipped. Files which are not UTF-8 are decoded with the legacy
+// encoding. Results are sorted by filepath and line number.
+func Files(filepaths []string, m *search.Matcher) []Result {
+	ret := []Result{}
+	mu := sync.Mutex{}
+	each(filepaths, func(fp string) {
+		if res := file(fp, m); len(res) > 0 {
+			mu.Lock()
+			ret = append(ret, res...)
+			mu.Unlock()
+		}
+	})
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Filepath != ret[j].Filepath {
+			return ret[i].Filepath < ret[j].Filepath
+		}
+		return ret[i].Lineno < ret[j].Lineno
+	})
+	if len(ret) > MAXRESULTS {
+		ret = ret[:MAXRESULTS]
+	}
+	return ret
+}
+
+// Count returns the number of matches in each of the given files with
+// matches. Unlike with Files, the files are matched as buffers, so
+// matches may span lines, and there is no limit to the results. The
+// same files are skipped as with Files.
+func Count(filepaths []string, m *search.Matcher) map[string]int {
+	ret := map[string]int{}
+	mu := sync.Mutex{}
+	each(filepaths, func(fp string) {
+		if n := count(fp, m); n > 0 {
+			mu.Lock()
+			ret[fp] = n
+			mu.Unlock()
+		}
+	})
+	return ret
+}
+
+// each calls fn for the filepaths concurrently.
+func each(filepaths []string, fn func(fp string)) {
+	jobs := make(chan string)
+	wg := sync.WaitGroup{}
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fp := range jobs {
+				fn(fp)
+			}
+		}()
+	}
+	for _, fp := range filepaths {
+		jobs <- fp
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func count(fp string, m *search.Matcher) int {
+	data, err := read(fp)
+	if err != nil {
+		log.Printf("[grep, %s] %v\n", fp, err)
+		return 0
+	}
+	if data == nil {
+		return 0
+	}
+	b, err := buffer.NewFromReader(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("[grep, %s] %v\n", fp, err)
+		return 0
+	}
+	return len(b.FindAll(m, &buffer.SearchLimit{
+		EndLineno: b.Lines() - 1,
+		EndCol:    b.LineLength(b.Lines() - 1),
+	}))
+}
+
+func file(fp string, m *search.Matcher) []Result {
+	data, err := read(fp)
+	if err != nil {
+		log.Printf("[grep, %s] %v\n", fp, err)
+		return nil
+	}
+	ret := []Result{}
+	for lineno, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		all := m.FindAll(line)
+		if len(all) == 0 {
+			continue
+		}
+		ret = append(ret, Result{
+			Filepath: fp,
+			Lineno:   lineno,
+			Col:      utf8.RuneCountInString(line[:all[0][0]]),
+			Line:     line,
+			Matches:  len(all),
+		})
+		if len(ret) >= MAXRESULTS {
+			break
+		}
+	}
+	return ret
+}
+
+// read returns the contents of fp as UTF-8 or nil, if it should not be
+// searched.
+func read(fp string) ([]byte, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > config.WARNFILESZ {
+		return nil, nil
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	switch buffer.Sniff(data) {
+	case buffer.CONTENT_BINARY:
+		return nil, nil
+	case buffer.CONTENT_LEGACY:
+		enc, err := buffer.LookupEncoding(config.LEGACY_ENCODING)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = enc.NewDecoder().Bytes(data); err != nil {
+			return nil, err
+		}
+	}
+	return bytes.TrimPrefix(data, buffer.BOM), nil
+}
@@ -0,0 +1,73 @@
+package grep_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/susji/ked/config"
+	"github.com/susji/ked/grep"
+	tu "github.com/susji/ked/internal/testutil"
+	"github.com/susji/ked/search"
+)
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"a.go":     []byte("package a\n\nfunc Foo() {}\r\n\tfoo()\n"),
+		"b.txt":    []byte("nothing here\n"),
+		"c.txt":    []byte("caf\xe9 foo FOO\n"),
+		"d.bin":    []byte("foo\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"),
+		"large.go": []byte("foo foo foo foo foo foo foo foo foo foo\n"),
+	}
+	paths := []string{}
+	for fn, data := range files {
+		fp := filepath.Join(dir, fn)
+		tu.Assert(t, os.WriteFile(fp, data, 0o600) == nil, "cannot write %s", fp)
+		paths = append(paths, fp)
+	}
+	paths = append(paths, filepath.Join(dir, "missing"))
+
+	prev := config.WARNFILESZ
+	config.WARNFILESZ = 36
+	defer func() { config.WARNFILESZ = prev }()
+
+	m, err := search.Compile("foo", search.Options{})
+	tu.Assert(t, err == nil, "unexpected error: %v", err)
+	got := grep.Files(paths, m)
+	want := []grep.Result{
+		{filepath.Join(dir, "a.go"), 2, 5, "func Foo() {}", 1},
+		{filepath.Join(dir, "a.go"), 3, 1, "\tfoo()", 1},
+		{filepath.Join(dir, "c.txt"), 0, 5, "café foo FOO", 2},
+	}
+	tu.Assert(t, reflect.DeepEqual(got, want), "want %q, got %q", want, got)
+
+	counts := grep.Count(paths, m)
+	wantcounts := map[string]int{
+		filepath.Join(dir, "a.go"):  2,
+		filepath.Join(dir, "c.txt"): 2,
+	}
+	tu.Assert(t, reflect.DeepEqual(counts, wantcounts), "want %v, got %v", wantcounts, counts)
+}
+
+func TestCount(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{}
+	for i := 0; i < 3; i++ {
+		fp := filepath.Join(dir, fmt.Sprintf("%d.txt", i))
+		data := strings.Repeat("foo\r\nbar\r\n", grep.MAXRESULTS)
+		tu.Assert(t, os.WriteFile(fp, []byte(data), 0o600) == nil, "cannot write %s", fp)
+		paths = append(paths, fp)
+	}
+
+	m, err := search.Compile(`foo\nbar`, search.Options{Regexp: true})
+	tu.Assert(t, err == nil, "unexpected error: %v", err)
+	counts := grep.Count(paths, m)
+	tu.Assert(t, len(counts) == 3, "want all files, got %v", counts)
+	for fp, n := range counts {
+		tu.Assert(t, n == grep.MAXRESULTS, "%s: want %d, got %d", fp, grep.MAXRESULTS, n)
+	}
+}
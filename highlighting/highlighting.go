@@ -84,7 +84,19 @@ func (h *highlighter) Keyword(
 	return h
 }
 
+// newstyles allocates the styles of a line. Very long lines are not
+// highlighted at all as that would be slow and take lots of memory.
+func newstyles(line []rune) []highlight {
+	if len(line) > config.MAXHIGHLIGHTLEN {
+		return nil
+	}
+	return make([]highlight, len(line))
+}
+
 func (h *highlighter) analyzeline(lineno int, line []rune) {
+	if h.styles[lineno] == nil {
+		return
+	}
 	for _, mapping := range h.mappings {
 		l := string(line)
 		runeacc := 0
@@ -96,7 +108,7 @@ func (h *highlighter) analyzeline(lineno int, line []rune) {
 			lefti := ix[mapping.lefti]
 			righti := ix[mapping.righti]
 			left := utf8.RuneCountInString(l[:lefti])
-			right := utf8.RuneCountInString(l[:righti])
+			right := left + utf8.RuneCountInString(l[lefti:righti])
 			for col := runeacc + left; col < runeacc+right; col++ {
 				prevpri := h.styles[lineno][col].priority
 				prevcol := h.styles[lineno][col].begincol
@@ -116,6 +128,13 @@ func (h *highlighter) analyzeline(lineno int, line []rune) {
 					break
 				}
 			}
+			if righti == 0 {
+				// Empty matches at the beginning would
+				// never advance.
+				_, sz := utf8.DecodeRuneInString(l)
+				righti = sz
+				right = 1
+			}
 			// We skip over this many runes due to present match.
 			runeacc += right
 			l = l[righti:]
@@ -126,7 +145,7 @@ func (h *highlighter) analyzeline(lineno int, line []rune) {
 func (h *highlighter) Analyze() Highlighting {
 	h.styles = [][]highlight{}
 	for lineno, line := range h.source {
-		h.styles = append(h.styles, make([]highlight, len(line)))
+		h.styles = append(h.styles, newstyles(line))
 		h.analyzeline(lineno, line)
 	}
 	return h
@@ -141,7 +160,7 @@ func (h *highlighter) DeleteLine(lineno int) Highlighting {
 }
 
 func (h *highlighter) ModifyLine(lineno int, newline []rune) Highlighting {
-	h.styles[lineno] = make([]highlight, len(newline))
+	h.styles[lineno] = newstyles(newline)
 	h.analyzeline(lineno, newline)
 	return h
 }
@@ -149,7 +168,7 @@ func (h *highlighter) ModifyLine(lineno int, newline []rune) Highlighting {
 func (h *highlighter) InsertLine(lineno int, newline []rune) Highlighting {
 	h.styles = append(h.styles, []highlight{})
 	copy(h.styles[lineno+1:], h.styles[lineno:])
-	h.styles[lineno] = make([]highlight, len(newline))
+	h.styles[lineno] = newstyles(newline)
 	h.analyzeline(lineno, newline)
 	return h
 }
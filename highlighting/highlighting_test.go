@@ -1,6 +1,7 @@
 package highlighting_test
 
 import (
+	"strings"
 	"testing"
 	"unicode/utf8"
 
@@ -144,3 +145,28 @@ func TestPartialRenalysis(t *testing.T) {
 	tu.Assert(t, s == g5, "got %x, want %x", g5, s)
 	tu.Assert(t, s0 == g6, "got %x, want %x", g6, s0)
 }
+
+func TestLongLine(t *testing.T) {
+	long := []rune(strings.Repeat("x ", config.MAXHIGHLIGHTLEN))
+	msg := [][]rune{
+		[]rune("x short"),
+		long,
+	}
+	s1 := config.STYLE_DEFAULT.Bold(true)
+	h := hl.New(msg).Keyword("x", s1, 1).Analyze()
+
+	g0 := h.Get(0, 0)
+	g1 := h.Get(1, 0)
+	tu.Assert(t, s1 == g0, "got %x, want %x", g0, s1)
+	tu.Assert(t, config.STYLE_DEFAULT == g1, "long line should not be highlighted, got %x", g1)
+}
+
+func TestEmptyMatch(t *testing.T) {
+	msg := [][]rune{
+		[]rune("abc"),
+	}
+	s1 := config.STYLE_DEFAULT.Bold(true)
+	h := hl.New(msg).Pattern("(x*)", 0, 1, s1, 1).Analyze()
+	g0 := h.Get(0, 0)
+	tu.Assert(t, config.STYLE_DEFAULT == g0, "got %x, want default", g0)
+}
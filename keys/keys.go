@@ -0,0 +1,322 @@
+// package keys maps key presses to the names of editor commands.
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+var ErrorInvalidKey = errors.New("invalid key")
+
+// Key is a key press with its modifiers. Runes are given as they were
+// typed, so Shift is not used with them. Ctrl is not used with the
+// control keys, which are distinct keys of their own.
+type Key struct {
+	Key  tcell.Key
+	Rune rune
+	Mod  tcell.ModMask
+}
+
+var specials = map[string]tcell.Key{
+	"Enter":     tcell.KeyEnter,
+	"Tab":       tcell.KeyTab,
+	"Backtab":   tcell.KeyBacktab,
+	"Backspace": tcell.KeyBackspace2,
+	"Delete":    tcell.KeyDelete,
+	"Insert":    tcell.KeyInsert,
+	"Home":      tcell.KeyHome,
+	"End":       tcell.KeyEnd,
+	"PgUp":      tcell.KeyPgUp,
+	"PgDn":      tcell.KeyPgDn,
+	"Up":        tcell.KeyUp,
+	"Down":      tcell.KeyDown,
+	"Left":      tcell.KeyLeft,
+	"Right":     tcell.KeyRight,
+	"Esc":       tcell.KeyEsc,
+	"F1":        tcell.KeyF1,
+	"F2":        tcell.KeyF2,
+	"F3":        tcell.KeyF3,
+	"F4":        tcell.KeyF4,
+	"F5":        tcell.KeyF5,
+	"F6":        tcell.KeyF6,
+	"F7":        tcell.KeyF7,
+	"F8":        tcell.KeyF8,
+	"F9":        tcell.KeyF9,
+	"F10":       tcell.KeyF10,
+	"F11":       tcell.KeyF11,
+	"F12":       tcell.KeyF12,
+}
+
+// controls are the control keys which are not letters.
+var controls = map[string]tcell.Key{
+	"Space": tcell.KeyCtrlSpace,
+	"_":     tcell.KeyCtrlUnderscore,
+	"\\":    tcell.KeyCtrlBackslash,
+	"]":     tcell.KeyCtrlRightSq,
+	"^":     tcell.KeyCtrlCarat,
+}
+
+func iscontrol(k tcell.Key) bool {
+	return (k >= tcell.KeyCtrlSpace && k <= tcell.KeyCtrlUnderscore) ||
+		k == tcell.KeyBackspace2
+}
+
+func lookup(m map[string]tcell.Key, name string) (tcell.Key, bool) {
+	for candidate, k := range m {
+		if strings.EqualFold(candidate, name) {
+			return k, true
+		}
+	}
+	return 0, false
+}
+
+// Parse parses keys like "Ctrl+X", "Alt+Left", or "F1". Letters are
+// case-insensitive, so uppercase letters are given with Shift like
+// "Alt+Shift+R".
+func Parse(spec string) (Key, error) {
+	parts := strings.Split(spec, "+")
+	name := parts[len(parts)-1]
+	mods := parts[:len(parts)-1]
+	if n := len(parts); n > 1 && len(parts[n-1]) == 0 && len(parts[n-2]) == 0 {
+		// The key itself is a plus.
+		name = "+"
+		mods = parts[:n-2]
+	}
+	invalid := fmt.Errorf("%w: %q", ErrorInvalidKey, spec)
+	ret := Key{}
+	ctrl := false
+	for _, mod := range mods {
+		switch strings.ToLower(mod) {
+		case "ctrl":
+			ctrl = true
+		case "alt", "meta":
+			ret.Mod |= tcell.ModAlt
+		case "shift":
+			ret.Mod |= tcell.ModShift
+		default:
+			return Key{}, invalid
+		}
+	}
+
+	if k, ok := lookup(specials, name); ok {
+		ret.Key = k
+		if ctrl {
+			ret.Mod |= tcell.ModCtrl
+		}
+		return ret, nil
+	}
+	if ctrl {
+		ret.Mod &^= tcell.ModShift
+		if k, ok := lookup(controls, name); ok {
+			ret.Key = k
+			return ret, nil
+		}
+		r, sz := utf8.DecodeRuneInString(name)
+		r = unicode.ToLower(r)
+		if sz != len(name) || r < 'a' || r > 'z' {
+			return Key{}, invalid
+		}
+		ret.Key = tcell.KeyCtrlA + tcell.Key(r-'a')
+		return ret, nil
+	}
+	if strings.EqualFold(name, "Space") {
+		name = " "
+	}
+	r, sz := utf8.DecodeRuneInString(name)
+	if sz != len(name) || r == utf8.RuneError {
+		return Key{}, invalid
+	}
+	ret.Key = tcell.KeyRune
+	ret.Rune = unicode.ToLower(r)
+	if ret.Mod&tcell.ModShift > 0 {
+		if !unicode.IsLetter(r) {
+			return Key{}, invalid
+		}
+		ret.Rune = unicode.ToUpper(r)
+		ret.Mod &^= tcell.ModShift
+	}
+	return ret, nil
+}
+
+// FromEvent returns the Key pressed in ev.
+func FromEvent(ev *tcell.EventKey) Key {
+	k, mod := ev.Key(), ev.Modifiers()
+	if mod&tcell.ModMeta > 0 {
+		mod |= tcell.ModAlt
+	}
+	mod &= tcell.ModShift | tcell.ModCtrl | tcell.ModAlt
+	switch {
+	case k == tcell.KeyRune:
+		return Key{Key: k, Rune: ev.Rune(), Mod: mod &^ tcell.ModShift}
+	case k == tcell.KeyBackspace:
+		k = tcell.KeyBackspace2
+	}
+	if iscontrol(k) {
+		mod &^= tcell.ModCtrl
+	}
+	return Key{Key: k, Mod: mod}
+}
+
+func (k Key) String() string {
+	ctrl := k.Mod&tcell.ModCtrl > 0
+	shift := k.Mod&tcell.ModShift > 0
+	var name string
+	switch {
+	case k.Key == tcell.KeyRune && k.Rune == ' ':
+		name = "Space"
+	case k.Key == tcell.KeyRune:
+		shift = unicode.IsUpper(k.Rune)
+		name = string(unicode.ToUpper(k.Rune))
+	case k.Key >= tcell.KeyCtrlA && k.Key <= tcell.KeyCtrlZ &&
+		k.Key != tcell.KeyTab && k.Key != tcell.KeyEnter:
+		ctrl = true
+		name = string(rune('A' + k.Key - tcell.KeyCtrlA))
+	}
+	for candidate, special := range specials {
+		if len(name) == 0 && k.Key == special {
+			name = candidate
+		}
+	}
+	for candidate, control := range controls {
+		if len(name) == 0 && k.Key == control {
+			ctrl = true
+			name = candidate
+		}
+	}
+	if len(name) == 0 {
+		name = fmt.Sprintf("Key%d", k.Key)
+	}
+	parts := []string{}
+	if ctrl {
+		parts = append(parts, "Ctrl")
+	}
+	if k.Mod&tcell.ModAlt > 0 {
+		parts = append(parts, "Alt")
+	}
+	if shift {
+		parts = append(parts, "Shift")
+	}
+	return strings.Join(append(parts, name), "+")
+}
+
+// Sequence is a series of keys pressed one after another.
+type Sequence []Key
+
+// ParseSequence parses key sequences separated by whitespace like
+// "Ctrl+X Ctrl+S".
+func ParseSequence(spec string) (Sequence, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrorInvalidKey, spec)
+	}
+	ret := Sequence{}
+	for _, field := range fields {
+		k, err := Parse(field)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, k)
+	}
+	return ret, nil
+}
+
+func (seq Sequence) String() string {
+	parts := []string{}
+	for _, k := range seq {
+		parts = append(parts, k.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// Keymap maps key sequences to command names. A key which begins
+// longer sequences is a prefix key, and it is not bound to a command
+// itself.
+type Keymap struct {
+	command string
+	next    map[Key]*Keymap
+}
+
+func NewKeymap() *Keymap {
+	return &Keymap{next: map[Key]*Keymap{}}
+}
+
+// Bind binds the key sequence given by spec to command. The command
+// "none" removes the binding. Binding a sequence replaces the bindings
+// of its prefixes and of the sequences it begins.
+func (km *Keymap) Bind(spec, command string) error {
+	seq, err := ParseSequence(spec)
+	if err != nil {
+		return err
+	}
+	km.bind(seq, command)
+	return nil
+}
+
+func (km *Keymap) bind(seq Sequence, command string) {
+	k := seq[0]
+	if len(seq) == 1 {
+		if command == "none" {
+			delete(km.next, k)
+		} else {
+			km.next[k] = &Keymap{command: command, next: map[Key]*Keymap{}}
+		}
+		return
+	}
+	next, ok := km.next[k]
+	if !ok || len(next.command) > 0 {
+		if command == "none" {
+			return
+		}
+		next = NewKeymap()
+	}
+	next.bind(seq[1:], command)
+	if len(next.next) == 0 {
+		delete(km.next, k)
+	} else {
+		km.next[k] = next
+	}
+}
+
+// Lookup returns the command bound to seq. If seq begins longer
+// sequences, prefix is true. Keys other than runes without a binding of
+// their own fall back to the key without Shift and Ctrl, so that
+// Shift+Up moves up like Up unless it is bound to something else.
+func (km *Keymap) Lookup(seq Sequence) (command string, prefix bool) {
+	cur := km
+	for _, k := range seq {
+		next, ok := cur.next[k]
+		if !ok && k.Key != tcell.KeyRune && k.Mod&(tcell.ModShift|tcell.ModCtrl) > 0 {
+			next, ok = cur.next[Key{Key: k.Key, Mod: k.Mod &^ (tcell.ModShift | tcell.ModCtrl)}]
+		}
+		if !ok {
+			return "", false
+		}
+		cur = next
+	}
+	return cur.command, len(cur.next) > 0
+}
+
+// Keys returns the key sequences bound to command in a stable order.
+func (km *Keymap) Keys(command string) []Sequence {
+	ret := []Sequence{}
+	var walk func(km *Keymap, seq Sequence)
+	walk = func(km *Keymap, seq Sequence) {
+		if len(km.command) > 0 && km.command == command {
+			ret = append(ret, append(Sequence{}, seq...))
+		}
+		for k, next := range km.next {
+			walk(next, append(seq, k))
+		}
+	}
+	walk(km, Sequence{})
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].String() < ret[j].String()
+	})
+	return ret
+}
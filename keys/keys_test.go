@@ -0,0 +1,145 @@
+package keys_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	tu "github.com/susji/ked/internal/testutil"
+	"github.com/susji/ked/keys"
+)
+
+func TestParse(t *testing.T) {
+	table := []struct {
+		spec string
+		want keys.Key
+		str  string
+	}{
+		{"Ctrl+X", keys.Key{Key: tcell.KeyCtrlX}, "Ctrl+X"},
+		{"ctrl+x", keys.Key{Key: tcell.KeyCtrlX}, "Ctrl+X"},
+		{"Ctrl+Space", keys.Key{Key: tcell.KeyCtrlSpace}, "Ctrl+Space"},
+		{"Ctrl+_", keys.Key{Key: tcell.KeyCtrlUnderscore}, "Ctrl+_"},
+		{"Alt+R", keys.Key{Key: tcell.KeyRune, Rune: 'r', Mod: tcell.ModAlt}, "Alt+R"},
+		{"Alt+Shift+R", keys.Key{Key: tcell.KeyRune, Rune: 'R', Mod: tcell.ModAlt}, "Alt+Shift+R"},
+		{"Alt+_", keys.Key{Key: tcell.KeyRune, Rune: '_', Mod: tcell.ModAlt}, "Alt+_"},
+		{"Alt++", keys.Key{Key: tcell.KeyRune, Rune: '+', Mod: tcell.ModAlt}, "Alt++"},
+		{"Alt+Space", keys.Key{Key: tcell.KeyRune, Rune: ' ', Mod: tcell.ModAlt}, "Alt+Space"},
+		{"Alt+Up", keys.Key{Key: tcell.KeyUp, Mod: tcell.ModAlt}, "Alt+Up"},
+		{"Ctrl+Left", keys.Key{Key: tcell.KeyLeft, Mod: tcell.ModCtrl}, "Ctrl+Left"},
+		{"Alt+Ctrl+X", keys.Key{Key: tcell.KeyCtrlX, Mod: tcell.ModAlt}, "Ctrl+Alt+X"},
+		{"pgdn", keys.Key{Key: tcell.KeyPgDn}, "PgDn"},
+		{"F1", keys.Key{Key: tcell.KeyF1}, "F1"},
+		{"Backspace", keys.Key{Key: tcell.KeyBackspace2}, "Backspace"},
+		{"Tab", keys.Key{Key: tcell.KeyTab}, "Tab"},
+	}
+	for _, e := range table {
+		t.Run(e.spec, func(t *testing.T) {
+			got, err := keys.Parse(e.spec)
+			tu.Assert(t, err == nil, "unexpected error: %v", err)
+			tu.Assert(t, got == e.want, "want %#v, got %#v", e.want, got)
+			tu.Assert(t, got.String() == e.str, "want %q, got %q", e.str, got.String())
+			again, err := keys.Parse(got.String())
+			tu.Assert(t, err == nil && again == got, "%q should parse back, got %#v", got, again)
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{"", "Ctrl+", "Alt+X+", "Hyper+X", "Ctrl+1", "Alt+Shift+1", "Ctrl+XY", "Foo"} {
+		_, err := keys.Parse(spec)
+		tu.Assert(t, errors.Is(err, keys.ErrorInvalidKey), "%q should be invalid, got %v", spec, err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	km := keys.NewKeymap()
+	tu.Assert(t, km.Bind("Ctrl+S", "search") == nil, "bind failed")
+	tu.Assert(t, km.Bind("Alt+R", "query-replace") == nil, "bind failed")
+	tu.Assert(t, km.Bind("Alt+Shift+R", "replace-in-files") == nil, "bind failed")
+	tu.Assert(t, km.Bind("Backspace", "backspace") == nil, "bind failed")
+	tu.Assert(t, km.Bind("Ctrl+Q", "quit") == nil, "bind failed")
+	tu.Assert(t, km.Bind("Ctrl+Q", "none") == nil, "unbind failed")
+	tu.Assert(t, km.Bind("Up", "move-up") == nil, "bind failed")
+	tu.Assert(t, km.Bind("Left", "move-left") == nil, "bind failed")
+	tu.Assert(t, km.Bind("Alt+Left", "word-left") == nil, "bind failed")
+	tu.Assert(t, km.Bind("Ctrl+Right", "word-right") == nil, "bind failed")
+	tu.Assert(t, km.Bind("Right", "move-right") == nil, "bind failed")
+
+	table := []struct {
+		ev   *tcell.EventKey
+		want string
+	}{
+		{tcell.NewEventKey(tcell.KeyCtrlS, 0, tcell.ModCtrl), "search"},
+		{tcell.NewEventKey(tcell.KeyCtrlS, 0, tcell.ModNone), "search"},
+		{tcell.NewEventKey(tcell.KeyRune, 'r', tcell.ModAlt), "query-replace"},
+		{tcell.NewEventKey(tcell.KeyRune, 'r', tcell.ModMeta), "query-replace"},
+		{tcell.NewEventKey(tcell.KeyRune, 'R', tcell.ModAlt|tcell.ModShift), "replace-in-files"},
+		{tcell.NewEventKey(tcell.KeyBackspace, 0, 0), "backspace"},
+		{tcell.NewEventKey(tcell.KeyBackspace2, 0, 0), "backspace"},
+		{tcell.NewEventKey(tcell.KeyRune, 'r', 0), ""},
+		{tcell.NewEventKey(tcell.KeyCtrlQ, 0, tcell.ModCtrl), ""},
+		{tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModShift), "move-up"},
+		{tcell.NewEventKey(tcell.KeyLeft, 0, tcell.ModCtrl), "move-left"},
+		{tcell.NewEventKey(tcell.KeyLeft, 0, tcell.ModCtrl|tcell.ModShift), "move-left"},
+		{tcell.NewEventKey(tcell.KeyLeft, 0, tcell.ModAlt|tcell.ModShift), "word-left"},
+		{tcell.NewEventKey(tcell.KeyRight, 0, tcell.ModCtrl), "word-right"},
+		{tcell.NewEventKey(tcell.KeyRight, 0, tcell.ModShift), "move-right"},
+		{tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModAlt), ""},
+		{tcell.NewEventKey(tcell.KeyRune, 'R', tcell.ModShift), ""},
+	}
+	for _, e := range table {
+		got, _ := km.Lookup(keys.Sequence{keys.FromEvent(e.ev)})
+		tu.Assert(t, got == e.want, "%s: want %q, got %q", e.ev.Name(), e.want, got)
+	}
+
+	ks := km.Keys("search")
+	tu.Assert(t, len(ks) == 1 && ks[0].String() == "Ctrl+S", "unexpected keys %v", ks)
+}
+
+func TestSequences(t *testing.T) {
+	km := keys.NewKeymap()
+	tu.Assert(t, km.Bind("Ctrl+X", "quit") == nil, "bind failed")
+	tu.Assert(t, km.Bind("Ctrl+X  Ctrl+S", "save") == nil, "bind failed")
+	tu.Assert(t, km.Bind("Ctrl+X K", "close-buffer") == nil, "bind failed")
+	tu.Assert(t, km.Bind("Ctrl+C Ctrl+C", "quit") == nil, "bind failed")
+	tu.Assert(t, km.Bind("Ctrl+X Ctrl+Q Ctrl+Q", "none") == nil, "unbind failed")
+
+	seq := func(spec string) keys.Sequence {
+		ret, err := keys.ParseSequence(spec)
+		tu.Assert(t, err == nil, "%s: %v", spec, err)
+		return ret
+	}
+	table := []struct {
+		seq     string
+		command string
+		prefix  bool
+	}{
+		{"Ctrl+X", "", true},
+		{"Ctrl+X Ctrl+S", "save", false},
+		{"Ctrl+X k", "close-buffer", false},
+		{"Ctrl+X Ctrl+Q", "", false},
+		{"Ctrl+X Ctrl+S Ctrl+S", "", false},
+		{"Ctrl+C", "", true},
+		{"Ctrl+S", "", false},
+	}
+	for _, e := range table {
+		command, prefix := km.Lookup(seq(e.seq))
+		tu.Assert(t, command == e.command, "%s: want %q, got %q", e.seq, e.command, command)
+		tu.Assert(t, prefix == e.prefix, "%s: want prefix %v", e.seq, e.prefix)
+	}
+
+	ks := km.Keys("quit")
+	tu.Assert(t, len(ks) == 1 && ks[0].String() == "Ctrl+C Ctrl+C", "unexpected keys %v", ks)
+
+	tu.Assert(t, km.Bind("Ctrl+X Ctrl+S", "none") == nil, "unbind failed")
+	tu.Assert(t, km.Bind("Ctrl+X K", "none") == nil, "unbind failed")
+	_, prefix := km.Lookup(seq("Ctrl+X"))
+	tu.Assert(t, !prefix, "Ctrl+X should not be a prefix anymore")
+
+	tu.Assert(t, km.Bind("Ctrl+C", "copy-region") == nil, "bind failed")
+	command, prefix := km.Lookup(seq("Ctrl+C"))
+	tu.Assert(t, command == "copy-region" && !prefix, "Ctrl+C should be rebound")
+
+	_, err := keys.ParseSequence("  ")
+	tu.Assert(t, err != nil, "empty sequence should be invalid")
+}
@@ -0,0 +1,98 @@
+package keys
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/susji/ked/util"
+)
+
+var ErrorInvalidName = errors.New("macro names cannot be empty or contain whitespace")
+
+// Macros are named key sequences. If Macros has a filepath, they are
+// persisted into it one per line with the name followed by the keys.
+type Macros struct {
+	path   string
+	macros map[string]Sequence
+}
+
+// NewMacros returns empty Macros persisted into path. An empty path
+// keeps the macros only in memory.
+func NewMacros(path string) *Macros {
+	return &Macros{
+		path:   path,
+		macros: map[string]Sequence{},
+	}
+}
+
+// LoadMacros reads the Macros persisted into path. A missing file is
+// not an error.
+func LoadMacros(path string) (*Macros, error) {
+	m := NewMacros(path)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	} else if err != nil {
+		return m, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	lineno := 0
+	for sc.Scan() {
+		lineno++
+		parts := strings.SplitN(strings.TrimSpace(sc.Text()), " ", 2)
+		if len(parts[0]) == 0 {
+			continue
+		}
+		if len(parts) != 2 {
+			return m, fmt.Errorf("%s:%d: missing keys", path, lineno)
+		}
+		seq, err := ParseSequence(parts[1])
+		if err != nil {
+			return m, fmt.Errorf("%s:%d: %w", path, lineno, err)
+		}
+		m.macros[parts[0]] = seq
+	}
+	return m, sc.Err()
+}
+
+// Names returns the names of the macros in sorted order.
+func (m *Macros) Names() []string {
+	ret := []string{}
+	for name := range m.macros {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Get returns the macro called name.
+func (m *Macros) Get(name string) (Sequence, bool) {
+	seq, ok := m.macros[name]
+	return seq, ok
+}
+
+// Save names the macro seq and persists the macros.
+func (m *Macros) Save(name string, seq Sequence) error {
+	if len(strings.Fields(name)) != 1 || strings.TrimSpace(name) != name {
+		return ErrorInvalidName
+	}
+	m.macros[name] = append(Sequence{}, seq...)
+	if len(m.path) == 0 {
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	for _, name := range m.Names() {
+		fmt.Fprintf(buf, "%s %s\n", name, m.macros[name])
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o700); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(m.path, buf.Bytes(), 0o600)
+}
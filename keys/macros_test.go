@@ -0,0 +1,44 @@
+package keys_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	tu "github.com/susji/ked/internal/testutil"
+	"github.com/susji/ked/keys"
+)
+
+func TestMacrosPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ked", "macros")
+	m, err := keys.LoadMacros(path)
+	tu.Assert(t, err == nil, "missing macros should be fine: %v", err)
+
+	seq, err := keys.ParseSequence("Ctrl+A Shift+H i Space + Alt+Backspace Down")
+	tu.Assert(t, err == nil, "unexpected error: %v", err)
+	tu.Assert(t, m.Save("greet", seq) == nil, "save failed")
+	tu.Assert(t, m.Save("down", keys.Sequence{seq[len(seq)-1]}) == nil, "save failed")
+	for _, name := range []string{"", " ", "two words", " padded"} {
+		tu.Assert(t, m.Save(name, seq) != nil, "%q should be invalid", name)
+	}
+
+	m, err = keys.LoadMacros(path)
+	tu.Assert(t, err == nil, "unexpected error: %v", err)
+	tu.Assert(t, reflect.DeepEqual(m.Names(), []string{"down", "greet"}), "unexpected names %v", m.Names())
+	got, ok := m.Get("greet")
+	tu.Assert(t, ok && reflect.DeepEqual(got, seq), "want %v, got %v", seq, got)
+	_, ok = m.Get("missing")
+	tu.Assert(t, !ok, "should not have a missing macro")
+}
+
+func TestMacrosInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "macros")
+	tu.Assert(t, os.WriteFile(path, []byte("broken Ctrl+Foo\n"), 0o600) == nil, "write failed")
+	_, err := keys.LoadMacros(path)
+	tu.Assert(t, err != nil, "should fail with an invalid key")
+
+	tu.Assert(t, os.WriteFile(path, []byte("nokeys\n"), 0o600) == nil, "write failed")
+	_, err = keys.LoadMacros(path)
+	tu.Assert(t, err != nil, "should fail without keys")
+}
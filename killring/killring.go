@@ -0,0 +1,83 @@
+// package killring maintains the text that has been recently killed
+// or copied so that it can be yanked back later.
+package killring
+
+const DEFAULTSZ = 32
+
+type KillRing struct {
+	entries [][][]rune
+	max     int
+	// yanked is the entry, which was most recently returned by
+	// Yank or Rotate, counted from the newest entry.
+	yanked int
+}
+
+func New(max int) *KillRing {
+	if max <= 0 {
+		panic("killring: max must be positive")
+	}
+	return &KillRing{max: max}
+}
+
+func copytext(text [][]rune) [][]rune {
+	ret := make([][]rune, len(text))
+	for i, line := range text {
+		ret[i] = append([]rune{}, line...)
+	}
+	return ret
+}
+
+// Push adds text as the newest entry of the ring. If the ring is
+// full, the oldest entry is forgotten.
+func (k *KillRing) Push(text [][]rune) {
+	if len(text) == 0 {
+		return
+	}
+	k.entries = append(k.entries, copytext(text))
+	if len(k.entries) > k.max {
+		k.entries[0] = nil
+		k.entries = k.entries[1:]
+	}
+	k.yanked = 0
+}
+
+// Append joins text to the end of the newest entry. This is used to
+// accumulate consecutive kills into one entry.
+func (k *KillRing) Append(text [][]rune) {
+	if len(k.entries) == 0 {
+		k.Push(text)
+		return
+	}
+	if len(text) == 0 {
+		return
+	}
+	newest := k.entries[len(k.entries)-1]
+	last := len(newest) - 1
+	newest[last] = append(newest[last], text[0]...)
+	newest = append(newest, copytext(text[1:])...)
+	k.entries[len(k.entries)-1] = newest
+	k.yanked = 0
+}
+
+func (k *KillRing) Len() int {
+	return len(k.entries)
+}
+
+// Yank returns the newest entry or nil if there is none.
+func (k *KillRing) Yank() [][]rune {
+	if len(k.entries) == 0 {
+		return nil
+	}
+	k.yanked = 0
+	return copytext(k.entries[len(k.entries)-1])
+}
+
+// Rotate returns the entry preceding the previously yanked one. After
+// the oldest entry, we wrap around to the newest one.
+func (k *KillRing) Rotate() [][]rune {
+	if len(k.entries) == 0 {
+		return nil
+	}
+	k.yanked = (k.yanked + 1) % len(k.entries)
+	return copytext(k.entries[len(k.entries)-1-k.yanked])
+}
@@ -0,0 +1,45 @@
+package killring_test
+
+import (
+	"reflect"
+	"testing"
+
+	tu "github.com/susji/ked/internal/testutil"
+	"github.com/susji/ked/killring"
+)
+
+func TestPushYankRotate(t *testing.T) {
+	k := killring.New(2)
+	tu.Assert(t, k.Yank() == nil, "empty ring should yank nil")
+
+	one := [][]rune{[]rune("one")}
+	two := [][]rune{[]rune("two"), []rune("lines")}
+	three := [][]rune{[]rune("three")}
+	k.Push(one)
+	k.Push(two)
+	k.Push(three)
+	tu.Assert(t, k.Len() == 2, "want 2 entries, got %d", k.Len())
+
+	got := k.Yank()
+	tu.Assert(t, reflect.DeepEqual(got, three), "want %q, got %q", three, got)
+	got = k.Rotate()
+	tu.Assert(t, reflect.DeepEqual(got, two), "want %q, got %q", two, got)
+	got = k.Rotate()
+	tu.Assert(t, reflect.DeepEqual(got, three), "want %q, got %q", three, got)
+}
+
+func TestAppend(t *testing.T) {
+	k := killring.New(killring.DEFAULTSZ)
+	k.Append([][]rune{[]rune("first line"), []rune("")})
+	k.Append([][]rune{[]rune("second line"), []rune("")})
+	k.Append([][]rune{[]rune("third")})
+
+	want := [][]rune{
+		[]rune("first line"),
+		[]rune("second line"),
+		[]rune("third"),
+	}
+	got := k.Yank()
+	tu.Assert(t, reflect.DeepEqual(got, want), "want %q, got %q", want, got)
+	tu.Assert(t, k.Len() == 1, "want 1 entry, got %d", k.Len())
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package recovery
+
+import (
+	"errors"
+	"syscall"
+)
+
+// alive tells whether a process with the given pid exists.
+func alive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,13 @@
+package recovery
+
+import "os"
+
+// alive tells whether a process with the given pid exists.
+func alive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
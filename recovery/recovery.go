@@ -0,0 +1,218 @@
+// Package recovery keeps journals of unsaved buffers so that their
+// contents survive a crash of the editor.
+package recovery
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/susji/ked/util"
+)
+
+const (
+	SUFFIX = ".journal"
+	MAGIC  = "ked-journal 1"
+)
+
+var ErrorBadJournal = errors.New("malformed journal")
+
+// Recovery writes the journals of a single editor process. Journals
+// are named by the process ID and the buffer ID so that several
+// editors may share the same directory.
+type Recovery struct {
+	dir string
+	pid int
+}
+
+// Journal is a journal left behind by an editor process which is no
+// longer running. Format describes the file format of the buffer.
+type Journal struct {
+	Path     string
+	Pid      int
+	Filepath string
+	Format   string
+	Saved    time.Time
+	Data     []byte
+}
+
+// New returns a Recovery writing journals into dir. An empty dir
+// disables journaling.
+func New(dir string) *Recovery {
+	return &Recovery{
+		dir: dir,
+		pid: os.Getpid(),
+	}
+}
+
+func (r *Recovery) Enabled() bool {
+	return r != nil && len(r.dir) > 0
+}
+
+func (r *Recovery) journalpath(bid uint32) string {
+	return filepath.Join(r.dir, fmt.Sprintf("%d-%d%s", r.pid, bid, SUFFIX))
+}
+
+// Write replaces the journal of buffer bid with data. filepath is the
+// file backing the buffer, if any, and format describes how the buffer
+// is written into the file. This way data may be kept as UTF-8 even if
+// it cannot be represented in the format.
+func (r *Recovery) Write(bid uint32, filepath, format string, data []byte) error {
+	if !r.Enabled() {
+		return nil
+	}
+	if err := os.MkdirAll(r.dir, 0700); err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, MAGIC)
+	fmt.Fprintln(buf, strconv.Quote(filepath))
+	fmt.Fprintln(buf, strconv.Quote(format))
+	fmt.Fprintln(buf, time.Now().Unix())
+	buf.Write(data)
+	return util.WriteFileAtomic(r.journalpath(bid), buf.Bytes(), 0600)
+}
+
+// Remove removes the journal of buffer bid if there is one.
+func (r *Recovery) Remove(bid uint32) error {
+	if !r.Enabled() {
+		return nil
+	}
+	if err := os.Remove(r.journalpath(bid)); err != nil &&
+		!errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// RemoveAll removes all journals written by this process.
+func (r *Recovery) RemoveAll() error {
+	if !r.Enabled() {
+		return nil
+	}
+	des, err := os.ReadDir(r.dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var reterr error
+	for _, de := range des {
+		pid, ok := parsename(de.Name())
+		if !ok || pid != r.pid {
+			continue
+		}
+		if err := os.Remove(filepath.Join(r.dir, de.Name())); err != nil {
+			reterr = err
+		}
+	}
+	return reterr
+}
+
+// Orphans returns the journals of editor processes which are no
+// longer running. Malformed journals are skipped.
+func (r *Recovery) Orphans() ([]*Journal, error) {
+	if !r.Enabled() {
+		return nil, nil
+	}
+	des, err := os.ReadDir(r.dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ret := []*Journal{}
+	for _, de := range des {
+		pid, ok := parsename(de.Name())
+		if !ok || pid == r.pid || alive(pid) {
+			continue
+		}
+		path := filepath.Join(r.dir, de.Name())
+		j, err := Read(path)
+		if err != nil {
+			log.Printf("[Orphans] %q: %v\n", path, err)
+			continue
+		}
+		j.Pid = pid
+		ret = append(ret, j)
+	}
+	return ret, nil
+}
+
+// Discard removes the journal.
+func (j *Journal) Discard() error {
+	return os.Remove(j.Path)
+}
+
+// Read parses the journal in path.
+func Read(path string) (*Journal, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	header := [4]string{}
+	for i := range header {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, ErrorBadJournal
+		}
+		header[i] = strings.TrimSuffix(line, "\n")
+	}
+	if header[0] != MAGIC {
+		return nil, ErrorBadJournal
+	}
+	fp, err := strconv.Unquote(header[1])
+	if err != nil {
+		return nil, ErrorBadJournal
+	}
+	format, err := strconv.Unquote(header[2])
+	if err != nil {
+		return nil, ErrorBadJournal
+	}
+	secs, err := strconv.ParseInt(header[3], 10, 64)
+	if err != nil {
+		return nil, ErrorBadJournal
+	}
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{
+		Path:     path,
+		Filepath: fp,
+		Format:   format,
+		Saved:    time.Unix(secs, 0),
+		Data:     data,
+	}, nil
+}
+
+func parsename(name string) (pid int, ok bool) {
+	if !strings.HasSuffix(name, SUFFIX) {
+		return 0, false
+	}
+	parts := strings.Split(strings.TrimSuffix(name, SUFFIX), "-")
+	if len(parts) != 2 {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, false
+	}
+	if _, err := strconv.ParseUint(parts[1], 10, 32); err != nil {
+		return 0, false
+	}
+	return pid, true
+}
@@ -0,0 +1,77 @@
+package recovery_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tu "github.com/susji/ked/internal/testutil"
+	"github.com/susji/ked/recovery"
+)
+
+func journals(t *testing.T, dir string) []string {
+	des, err := os.ReadDir(dir)
+	tu.Assert(t, err == nil, "cannot read dir: %v", err)
+	ret := []string{}
+	for _, de := range des {
+		ret = append(ret, de.Name())
+	}
+	return ret
+}
+
+func TestWriteRemove(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	r := recovery.New(dir)
+	tu.Assert(t, r.Remove(1) == nil, "removing nothing should succeed")
+
+	tu.Assert(t, r.Write(1, "/some/file", "LF", []byte("one\ntwo\n")) == nil, "write failed")
+	tu.Assert(t, r.Write(2, "", "LF", []byte("three\n")) == nil, "write failed")
+	tu.Assert(t, len(journals(t, dir)) == 2, "want two journals")
+
+	// Our own journals are never orphans.
+	orphans, err := r.Orphans()
+	tu.Assert(t, err == nil, "orphans failed: %v", err)
+	tu.Assert(t, len(orphans) == 0, "want no orphans, got %d", len(orphans))
+
+	tu.Assert(t, r.Remove(1) == nil, "remove failed")
+	tu.Assert(t, len(journals(t, dir)) == 1, "want one journal")
+	tu.Assert(t, r.RemoveAll() == nil, "remove all failed")
+	tu.Assert(t, len(journals(t, dir)) == 0, "want no journals")
+}
+
+func TestOrphans(t *testing.T) {
+	dir := t.TempDir()
+	r := recovery.New(dir)
+	tu.Assert(t, r.Write(7, "/some/file", "CRLF,ISO-8859-1", []byte("one\ntwo")) == nil, "write failed")
+
+	// Pretend the journal was left behind by a dead process.
+	name := fmt.Sprintf("%d-7%s", os.Getpid(), recovery.SUFFIX)
+	deadname := fmt.Sprintf("999999999-7%s", recovery.SUFFIX)
+	err := os.Rename(filepath.Join(dir, name), filepath.Join(dir, deadname))
+	tu.Assert(t, err == nil, "rename failed: %v", err)
+	err = os.WriteFile(
+		filepath.Join(dir, "999999999-8"+recovery.SUFFIX), []byte("garbage"), 0600)
+	tu.Assert(t, err == nil, "write failed: %v", err)
+
+	orphans, err := r.Orphans()
+	tu.Assert(t, err == nil, "orphans failed: %v", err)
+	tu.Assert(t, len(orphans) == 1, "want one orphan, got %d", len(orphans))
+	j := orphans[0]
+	tu.Assert(t, j.Pid == 999999999, "unexpected pid %d", j.Pid)
+	tu.Assert(t, j.Filepath == "/some/file", "unexpected filepath %q", j.Filepath)
+	tu.Assert(t, j.Format == "CRLF,ISO-8859-1", "unexpected format %q", j.Format)
+	tu.Assert(t, string(j.Data) == "one\ntwo", "unexpected data %q", j.Data)
+
+	tu.Assert(t, j.Discard() == nil, "discard failed")
+	orphans, err = r.Orphans()
+	tu.Assert(t, err == nil && len(orphans) == 0, "want no orphans")
+}
+
+func TestDisabled(t *testing.T) {
+	r := recovery.New("")
+	tu.Assert(t, !r.Enabled(), "should be disabled")
+	tu.Assert(t, r.Write(1, "", "LF", []byte("x")) == nil, "write should be a no-op")
+	orphans, err := r.Orphans()
+	tu.Assert(t, err == nil && len(orphans) == 0, "want no orphans")
+}
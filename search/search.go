@@ -0,0 +1,126 @@
+// Package search compiles search terms into regular expressions. Both
+// literal and regular expression terms are supported, and matching is
+// done over text where buffer lines are joined with newlines.
+package search
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/susji/ked/config"
+)
+
+type Options struct {
+	// Regexp means that the term is a regular expression in Go
+	// syntax instead of a literal string.
+	Regexp bool
+	// CaseSensitive matching is done always, and with SmartCase
+	// only if the term contains uppercase letters.
+	CaseSensitive bool
+	SmartCase     bool
+	// WholeWord matches must be surrounded by word delimiters or
+	// the ends of the text.
+	WholeWord bool
+}
+
+// Sensitive tells whether term is matched case-sensitively.
+func (o Options) Sensitive(term string) bool {
+	if o.CaseSensitive {
+		return true
+	}
+	if o.SmartCase {
+		return strings.IndexFunc(term, unicode.IsUpper) != -1
+	}
+	return false
+}
+
+type Matcher struct {
+	re   *regexp.Regexp
+	opts Options
+}
+
+// Compile returns a Matcher for term. In regular expressions, ^ and $
+// match at line boundaries.
+func Compile(term string, opts Options) (*Matcher, error) {
+	pattern := term
+	if !opts.Regexp {
+		pattern = regexp.QuoteMeta(term)
+	}
+	flags := "(?m)"
+	if !opts.Sensitive(term) {
+		flags = "(?im)"
+	}
+	re, err := regexp.Compile(flags + pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &Matcher{
+		re:   re,
+		opts: opts,
+	}, nil
+}
+
+// Find returns the byte offsets of the first match and its capture
+// groups in text like regexp.FindStringSubmatchIndex.
+func (m *Matcher) Find(text string) []int {
+	if !m.opts.WholeWord {
+		return m.re.FindStringSubmatchIndex(text)
+	}
+	all := m.FindAll(text)
+	if len(all) == 0 {
+		return nil
+	}
+	return all[0]
+}
+
+// FindAll returns the byte offsets of all matches in text. Note that
+// with WholeWord, a match which is not a whole word may hide an
+// overlapping one which is.
+func (m *Matcher) FindAll(text string) [][]int {
+	all := m.re.FindAllStringSubmatchIndex(text, -1)
+	if !m.opts.WholeWord {
+		return all
+	}
+	ret := [][]int{}
+	for _, ix := range all {
+		if wordboundary(text, ix[0], ix[1]) {
+			ret = append(ret, ix)
+		}
+	}
+	return ret
+}
+
+func isdelim(r rune) bool {
+	return r == '\n' || strings.ContainsRune(config.WORD_DELIMS, r)
+}
+
+// wordboundary tells whether text between start and end is surrounded
+// by word delimiters or the ends of text.
+func wordboundary(text string, start, end int) bool {
+	if start == end {
+		return false
+	}
+	if start > 0 {
+		r, _ := utf8.DecodeLastRuneInString(text[:start])
+		if !isdelim(r) {
+			return false
+		}
+	}
+	if end < len(text) {
+		r, _ := utf8.DecodeRuneInString(text[end:])
+		return isdelim(r)
+	}
+	return true
+}
+
+// Expand returns the replacement for the match at submatches within
+// text. With regular expressions, the template may refer to capture
+// groups like $1 or ${name}. Otherwise the template is used as is.
+func (m *Matcher) Expand(template, text string, submatches []int) string {
+	if !m.opts.Regexp {
+		return template
+	}
+	return string(m.re.ExpandString(nil, template, text, submatches))
+}
@@ -0,0 +1,80 @@
+package search_test
+
+import (
+	"testing"
+
+	tu "github.com/susji/ked/internal/testutil"
+	"github.com/susji/ked/search"
+)
+
+func TestLiteral(t *testing.T) {
+	m, err := search.Compile("a.b", search.Options{})
+	tu.Assert(t, err == nil, "unexpected error: %v", err)
+	text := "axb A.B a.b"
+	ix := m.Find(text)
+	tu.Assert(t, ix != nil && ix[0] == 4 && ix[1] == 7, "unexpected match %v", ix)
+	tu.Assert(t, len(m.FindAll(text)) == 2, "want two matches")
+	got := m.Expand("$1", text, ix)
+	tu.Assert(t, got == "$1", "literal replacement expanded: %q", got)
+}
+
+func TestRegexp(t *testing.T) {
+	opts := search.Options{Regexp: true}
+	_, err := search.Compile("(", opts)
+	tu.Assert(t, err != nil, "want error for invalid regexp")
+
+	m, err := search.Compile(`(\w+)=(\w+)`, opts)
+	tu.Assert(t, err == nil, "unexpected error: %v", err)
+	text := "one=1\ntwo=2"
+	all := m.FindAll(text)
+	tu.Assert(t, len(all) == 2, "want two matches, got %d", len(all))
+	got := m.Expand("$2=$1", text, all[1])
+	tu.Assert(t, got == "2=two", "unexpected expansion %q", got)
+}
+
+func TestMultiline(t *testing.T) {
+	m, err := search.Compile(`^two$`, search.Options{Regexp: true})
+	tu.Assert(t, err == nil, "unexpected error: %v", err)
+	ix := m.Find("one\ntwo\nthree")
+	tu.Assert(t, ix != nil && ix[0] == 4 && ix[1] == 7, "unexpected match %v", ix)
+
+	m, err = search.Compile(`e\nt`, search.Options{Regexp: true})
+	tu.Assert(t, err == nil, "unexpected error: %v", err)
+	ix = m.Find("one\ntwo\nthree")
+	tu.Assert(t, ix != nil && ix[0] == 2 && ix[1] == 5, "unexpected match %v", ix)
+}
+
+func TestCase(t *testing.T) {
+	text := "id ID Id"
+	type tc struct {
+		term string
+		opts search.Options
+		want int
+	}
+	tcs := []tc{
+		{"id", search.Options{}, 3},
+		{"ID", search.Options{}, 3},
+		{"id", search.Options{CaseSensitive: true}, 1},
+		{"Id", search.Options{CaseSensitive: true}, 1},
+		{"id", search.Options{SmartCase: true}, 3},
+		{"ID", search.Options{SmartCase: true}, 1},
+		{"I.", search.Options{SmartCase: true, Regexp: true}, 2},
+	}
+	for _, c := range tcs {
+		m, err := search.Compile(c.term, c.opts)
+		tu.Assert(t, err == nil, "unexpected error: %v", err)
+		got := len(m.FindAll(text))
+		tu.Assert(t, got == c.want, "%q %+v: want %d, got %d", c.term, c.opts, c.want, got)
+	}
+}
+
+func TestWholeWord(t *testing.T) {
+	m, err := search.Compile("id", search.Options{WholeWord: true})
+	tu.Assert(t, err == nil, "unexpected error: %v", err)
+	text := "id idx (id) pid\nid"
+	all := m.FindAll(text)
+	tu.Assert(t, len(all) == 3, "want three matches, got %d", len(all))
+	tu.Assert(t, all[1][0] == 8, "unexpected match %v", all[1])
+	ix := m.Find("idx id")
+	tu.Assert(t, ix != nil && ix[0] == 4, "unexpected match %v", ix)
+}
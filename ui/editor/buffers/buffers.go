@@ -24,13 +24,21 @@ type EditorBuffers struct {
 }
 
 type EditorBuffer struct {
-	Buffer                *buffer.Buffer
-	Viewport              *viewport.Viewport
-	Filepath              string
-	Hilite                highlighting.Highlighting
+	Buffer   *buffer.Buffer
+	Viewport *viewport.Viewport
+	Filepath string
+	Hilite   highlighting.Highlighting
+	// ReadOnly buffers are not edited by commands.
+	ReadOnly              bool
 	bid                   uint32
 	cursorline, cursorcol int
 	prevsearch            string
+	// The mark and the cursor form the region.
+	marked            bool
+	markline, markcol int
+	// stamp describes the backing file as we last saw it.
+	stamp         *FileStamp
+	changedondisk bool
 }
 
 func New() EditorBuffers {
@@ -107,3 +115,71 @@ func (eb *EditorBuffer) Id() BufferId {
 func (eb *EditorBuffer) SetHighlighting(hi highlighting.Highlighting) {
 	eb.Hilite = hi
 }
+
+func (eb *EditorBuffer) SetMark() {
+	eb.marked = true
+	eb.markline = eb.cursorline
+	eb.markcol = eb.cursorcol
+}
+
+func (eb *EditorBuffer) ClearMark() {
+	eb.marked = false
+}
+
+func (eb *EditorBuffer) Marked() bool {
+	return eb.marked
+}
+
+// Region returns the positions between the mark and the cursor in
+// buffer order. As the buffer may have been modified after setting
+// the mark, the mark is first clamped to the buffer.
+func (eb *EditorBuffer) Region() (startline, startcol, endline, endcol int, ok bool) {
+	if !eb.marked {
+		return 0, 0, 0, 0, false
+	}
+	if eb.markline > eb.Buffer.Lines()-1 {
+		eb.markline = eb.Buffer.Lines() - 1
+	}
+	if eb.markcol > eb.Buffer.LineLength(eb.markline) {
+		eb.markcol = eb.Buffer.LineLength(eb.markline)
+	}
+	startline, startcol = eb.markline, eb.markcol
+	endline, endcol = eb.cursorline, eb.cursorcol
+	if endline < startline || (endline == startline && endcol < startcol) {
+		startline, startcol, endline, endcol = endline, endcol, startline, startcol
+	}
+	return startline, startcol, endline, endcol, true
+}
+
+// UpdateStamp records the present state of the backing file.
+func (eb *EditorBuffer) UpdateStamp() error {
+	eb.stamp = nil
+	eb.changedondisk = false
+	if len(eb.Filepath) == 0 {
+		return nil
+	}
+	fs, err := NewFileStamp(eb.Filepath)
+	if err != nil {
+		return err
+	}
+	eb.stamp = fs
+	return nil
+}
+
+// CheckStamp tells whether the backing file has been changed since
+// the latest UpdateStamp.
+func (eb *EditorBuffer) CheckStamp() (bool, error) {
+	if eb.stamp == nil {
+		return false, nil
+	}
+	changed, err := eb.stamp.Changed(eb.Filepath)
+	if err != nil {
+		return false, err
+	}
+	eb.changedondisk = changed
+	return changed, nil
+}
+
+func (eb *EditorBuffer) ChangedOnDisk() bool {
+	return eb.changedondisk
+}
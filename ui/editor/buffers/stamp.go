@@ -0,0 +1,63 @@
+package buffers
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"time"
+)
+
+// FileStamp identifies the state of a file on disk so we can notice
+// when someone else has changed it.
+type FileStamp struct {
+	ModTime time.Time
+	Size    int64
+	Hash    [sha256.Size]byte
+}
+
+func NewFileStamp(path string) (*FileStamp, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	ret := &FileStamp{
+		ModTime: fi.ModTime(),
+		Size:    fi.Size(),
+	}
+	copy(ret.Hash[:], h.Sum(nil))
+	return ret, nil
+}
+
+// Changed tells whether the file at path differs from the stamp. The
+// file contents are hashed only if its modification time or size has
+// changed. This means that merely touching the file is not considered
+// a change, and the stamp takes the new modification time so that the
+// file is not hashed again.
+func (fs *FileStamp) Changed(path string) (bool, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if fi.ModTime().Equal(fs.ModTime) && fi.Size() == fs.Size {
+		return false, nil
+	}
+	cur, err := NewFileStamp(path)
+	if err != nil {
+		return false, err
+	}
+	if cur.Hash != fs.Hash {
+		return true, nil
+	}
+	fs.ModTime = cur.ModTime
+	fs.Size = cur.Size
+	return false, nil
+}
@@ -0,0 +1,43 @@
+package buffers_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tu "github.com/susji/ked/internal/testutil"
+	"github.com/susji/ked/ui/editor/buffers"
+)
+
+func TestFileStamp(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(fn, []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := buffers.NewFileStamp(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := fs.Changed(fn)
+	tu.Assert(t, err == nil && !changed, "should be unchanged: %t, %v", changed, err)
+
+	// Touching the file does not change it.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(fn, later, later); err != nil {
+		t.Fatal(err)
+	}
+	changed, err = fs.Changed(fn)
+	tu.Assert(t, err == nil && !changed, "should be unchanged: %t, %v", changed, err)
+	tu.Assert(t, fs.ModTime.Equal(later), "stamp should take the new time, got %v", fs.ModTime)
+
+	if err := os.WriteFile(fn, []byte("modified"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed, err = fs.Changed(fn)
+	tu.Assert(t, err == nil && changed, "should be changed: %t, %v", changed, err)
+
+	_, err = fs.Changed(filepath.Join(t.TempDir(), "missing"))
+	tu.Assert(t, err != nil, "missing file should error")
+}
@@ -0,0 +1,340 @@
+package editor
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/susji/ked/buffer"
+	"github.com/susji/ked/config"
+	"github.com/susji/ked/keys"
+	"github.com/susji/ked/ui/fuzzyselect"
+)
+
+// command is an editor action, which may be bound to keys by its name.
+type command struct {
+	fn  func(e *Editor)
+	doc string
+}
+
+// commands are initialized in init, as some commands refer to the
+// commands themselves.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"open-file":        {(*Editor).openbuffer, "Open a file from a directory"},
+		"new-buffer":       {(*Editor).newbuffer, "Open a new empty buffer"},
+		"switch-buffer":    {(*Editor).changebuffer, "Switch to another buffer"},
+		"close-buffer":     {(*Editor).closebuffercmd, "Close the current buffer"},
+		"save":             {(*Editor).savebuffer, "Save the buffer"},
+		"quit":             {(*Editor).quitcmd, "Exit the editor"},
+		"undo":             {edits((*Editor).undo), "Undo recent actions"},
+		"redo":             {edits((*Editor).redo), "Redo recently undone actions"},
+		"search":           {(*Editor).search, "Search the buffer"},
+		"replace":          {edits((*Editor).replace), "Replace all matches in the region or lines"},
+		"query-replace":    {edits((*Editor).queryreplace), "Replace matches asking for each"},
+		"grep":             {(*Editor).grepfiles, "Search the files under a directory"},
+		"replace-in-files": {(*Editor).replacefiles, "Replace matches in the files under a directory"},
+		"goto-line":        {(*Editor).jumpline, "Jump to a line"},
+		"kill":             {edits((*Editor).killcmd), "Cut the region or delete to the end of line"},
+		"set-mark":         {(*Editor).setmark, "Set or unset the mark"},
+		"copy-region":      {(*Editor).copyregion, "Copy the region into the kill ring"},
+		"yank":             {edits((*Editor).yanklatest), "Yank the latest kill ring entry"},
+		"yank-pop":         {edits((*Editor).yankpop), "Replace the yanked text with the previous entry"},
+		"paste":            {edits((*Editor).paste), "Paste from the system clipboard"},
+		"toggle-eol":       {edits((*Editor).toggleeol), "Switch the line endings between LF and CRLF"},
+		"newline":          {edits((*Editor).newline), "Insert a line break"},
+		"indent":           {edits((*Editor).indent), "Insert a tab or spaces"},
+		"unindent":         {edits((*Editor).backtab), "Remove one level of indentation"},
+		"backspace":        {edits((*Editor).backspace), "Delete the previous character"},
+		"delete-word":      {edits((*Editor).delword), "Delete the current word"},
+		"move-up":          {func(e *Editor) { e.movevertical(true) }, "Move up"},
+		"move-down":        {func(e *Editor) { e.movevertical(false) }, "Move down"},
+		"move-left":        {(*Editor).moveleft, "Move left"},
+		"move-right":       {(*Editor).moveright, "Move right"},
+		"line-start":       {func(e *Editor) { e.moveline(true) }, "Move to the beginning of line"},
+		"line-end":         {func(e *Editor) { e.moveline(false) }, "Move to the end of line"},
+		"page-up":          {func(e *Editor) { e.movepage(true) }, "Move one page up"},
+		"page-down":        {func(e *Editor) { e.movepage(false) }, "Move one page down"},
+		"word-left":        {func(e *Editor) { e.jumpword(true) }, "Jump to the previous word"},
+		"word-right":       {func(e *Editor) { e.jumpword(false) }, "Jump to the next word"},
+		"empty-line-up":    {func(e *Editor) { e.jumpempty(true) }, "Jump to the previous empty line"},
+		"empty-line-down":  {func(e *Editor) { e.jumpempty(false) }, "Jump to the next empty line"},
+		"command-palette":  {(*Editor).palette, "Choose a command to run"},
+		"help":             {(*Editor).help, "Show the commands, key bindings, and configuration"},
+		"macro-record":     {(*Editor).recordmacro, "Start or stop recording a macro"},
+		"macro-replay":     {(*Editor).replaymacro, "Stop recording or replay the macro"},
+		"macro-save":       {(*Editor).savemacro, "Save the macro with a name"},
+		"macro-run":        {(*Editor).runmacro, "Replay a saved macro"},
+	}
+}
+
+// defaultkeys are bound before the keys given in the configuration.
+var defaultkeys = []struct {
+	key, command string
+}{
+	{"Ctrl+X", "quit"},
+	{"Ctrl+W", "save"},
+	{"Ctrl+S", "search"},
+	{"Ctrl+R", "replace"},
+	{"Alt+R", "query-replace"},
+	{"Alt+Left", "word-left"},
+	{"Alt+Right", "word-right"},
+	{"Ctrl+A", "line-start"},
+	{"Ctrl+E", "line-end"},
+	{"Ctrl+G", "goto-line"},
+	{"PgUp", "page-up"},
+	{"PgDn", "page-down"},
+	{"Ctrl+K", "kill"},
+	{"Ctrl+Space", "set-mark"},
+	{"Alt+W", "copy-region"},
+	{"Ctrl+Y", "yank"},
+	{"Alt+Y", "yank-pop"},
+	{"Alt+V", "paste"},
+	{"Alt+Backspace", "delete-word"},
+	{"Ctrl+_", "undo"},
+	{"Alt+_", "redo"},
+	{"Tab", "indent"},
+	{"Backtab", "unindent"},
+	{"Alt+Up", "empty-line-up"},
+	{"Alt+Down", "empty-line-down"},
+	{"Ctrl+P", "switch-buffer"},
+	{"Ctrl+F", "open-file"},
+	{"Ctrl+N", "new-buffer"},
+	{"Alt+F", "close-buffer"},
+	{"Alt+G", "grep"},
+	{"Alt+Shift+R", "replace-in-files"},
+	{"Alt+L", "toggle-eol"},
+	{"Alt+X", "command-palette"},
+	{"F1", "help"},
+	{"F3", "macro-record"},
+	{"F4", "macro-replay"},
+	{"Enter", "newline"},
+	{"Backspace", "backspace"},
+	{"Up", "move-up"},
+	{"Down", "move-down"},
+	{"Left", "move-left"},
+	{"Right", "move-right"},
+}
+
+func defaultkeymap() *keys.Keymap {
+	km := keys.NewKeymap()
+	for _, dk := range defaultkeys {
+		if _, ok := commands[dk.command]; !ok {
+			panic(fmt.Sprintf("unknown default command: %s", dk.command))
+		}
+		if err := km.Bind(dk.key, dk.command); err != nil {
+			panic(fmt.Sprintf("invalid default key: %v", err))
+		}
+	}
+	return km
+}
+
+// BindKeys binds keys to commands over the default bindings. Invalid
+// keys and unknown commands are errors.
+func (e *Editor) BindKeys(bindings []config.KeyBinding) error {
+	for _, b := range bindings {
+		if _, ok := commands[b.Command]; !ok && b.Command != "none" {
+			return fmt.Errorf("%s:%d: unknown command %q", b.Fn, b.Lineno, b.Command)
+		}
+		if err := e.keymap.Bind(b.Key, b.Command); err != nil {
+			return fmt.Errorf("%s:%d: %w", b.Fn, b.Lineno, err)
+		}
+		log.Printf("[BindKeys] %s -> %s\n", b.Key, b.Command)
+	}
+	return nil
+}
+
+// handlekey runs the command bound to the key sequence ending with the
+// pressed key. Keys which begin longer sequences are kept pending until
+// the sequence is complete, undefined, cancelled with Ctrl+C, or it
+// times out. Runes without a binding are inserted into the buffer.
+// Any sequence which is not pending ends the chain of kills or yanks,
+// unless the command continues it.
+func (e *Editor) handlekey(ev *tcell.EventKey) {
+	seq := append(e.pending, keys.FromEvent(ev))
+	name, prefix := e.keymap.Lookup(seq)
+	if prefix {
+		e.pending = seq
+		e.pendingid++
+		if config.CHORDTIMEOUT > 0 {
+			id := e.pendingid
+			time.AfterFunc(config.CHORDTIMEOUT, func() {
+				e.s.PostEvent(tcell.NewEventInterrupt(chordtimeout(id)))
+			})
+		}
+		return
+	}
+	pending := e.pending
+	e.pending = nil
+	e.prevchain, e.chain = e.chain, chainnone
+	if len(name) > 0 {
+		log.Printf("[handlekey] %s: %s\n", seq, name)
+		e.cmdstart = len(e.ms.recorded) - len(seq)
+		commands[name].fn(e)
+		return
+	}
+	if len(pending) > 0 {
+		if ev.Key() == tcell.KeyCtrlC {
+			log.Printf("[handlekey] %s cancelled\n", pending)
+		} else {
+			log.Printf("[handlekey] %s is undefined\n", seq)
+		}
+		return
+	}
+	if ev.Key() == tcell.KeyRune {
+		edits(func(e *Editor) {
+			e.insertrune(ev.Rune())
+			e.setmodified(true)
+		})(e)
+	}
+}
+
+// edits wraps commands which edit the active buffer, so that they are
+// not run for read-only buffers.
+func edits(fn func(e *Editor)) func(e *Editor) {
+	return func(e *Editor) {
+		if e.readonly() {
+			e.statusmsg("Buffer is read-only")
+			return
+		}
+		fn(e)
+	}
+}
+
+func (e *Editor) readonly() bool {
+	return e.buffers.Get(e.activebuf).ReadOnly
+}
+
+// timeoutchord drops the pending key sequence, if it is the one which
+// timed out.
+func (e *Editor) timeoutchord(id int) {
+	if len(e.pending) == 0 || id != e.pendingid {
+		return
+	}
+	log.Printf("[timeoutchord] %s timed out\n", e.pending)
+	e.pending = nil
+	e.prevchain, e.chain = e.chain, chainnone
+}
+
+// commandnames returns the names of all commands in sorted order.
+func commandnames() []string {
+	ret := []string{}
+	for name := range commands {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// namewidth returns the length of the longest name for aligning the
+// columns after the names.
+func namewidth(names []string) int {
+	width := 0
+	for _, name := range names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	return width
+}
+
+// bindings returns the key sequences bound to the command name for
+// displaying.
+func (e *Editor) bindings(name string) string {
+	seqs := []string{}
+	for _, seq := range e.keymap.Keys(name) {
+		seqs = append(seqs, seq.String())
+	}
+	return strings.Join(seqs, ", ")
+}
+
+// palette lets the user choose any command with its description and
+// key bindings displayed, and runs it.
+func (e *Editor) palette() {
+	names := commandnames()
+	width := namewidth(names)
+	choices := []fuzzyselect.Entry{}
+	for i, name := range names {
+		display := fmt.Sprintf("%-*s  %s", width, name, commands[name].doc)
+		if bound := e.bindings(name); len(bound) > 0 {
+			display += fmt.Sprintf(" (%s)", bound)
+		}
+		choices = append(choices, fuzzyselect.Entry{
+			Display: []rune(display),
+			Id:      uint32(i),
+		})
+	}
+	w, h := e.s.Size()
+	sel, err := fuzzyselect.New(choices).Choose(e.s, 0, 0, w, h-2)
+	if err != nil {
+		log.Printf("[palette, fuzzy error] %v\n", err)
+		return
+	}
+	name := names[sel.Id]
+	log.Printf("[palette] %s\n", name)
+	e.redraw()
+	e.s.Show()
+	commands[name].fn(e)
+}
+
+func (e *Editor) newbuffer() {
+	e.NewFromBuffer("", buffer.New(nil))
+}
+
+func (e *Editor) closebuffercmd() {
+	e.closeactivebuffer(false)
+}
+
+func (e *Editor) quitcmd() {
+	if e.quit() {
+		e.quitting = true
+	}
+}
+
+func (e *Editor) killcmd() {
+	if e.buffers.Get(e.activebuf).Marked() {
+		e.cutregion()
+	} else {
+		e.delline()
+		e.setmodified(true)
+	}
+}
+
+func (e *Editor) yanklatest() {
+	e.yank(e.killring.Yank())
+}
+
+func (e *Editor) newline() {
+	e.insertlinefeed()
+	e.setmodified(true)
+}
+
+func (e *Editor) indent() {
+	eb := e.buffers.Get(e.activebuf)
+	c := config.GetEditorConfig(eb.Filepath)
+	if c.TabSpaces {
+		eb.Buffer.BeginGroup()
+		for i := 0; i < c.TabSize; i++ {
+			e.insertrune(' ')
+		}
+		eb.Buffer.EndGroup()
+	} else {
+		e.insertrune('\t')
+	}
+	e.setmodified(true)
+}
+
+func (e *Editor) backspace() {
+	e.backspaceordelword(true)
+	e.setmodified(true)
+}
+
+func (e *Editor) delword() {
+	e.backspaceordelword(false)
+	e.setmodified(true)
+}
@@ -0,0 +1,115 @@
+package editor
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/susji/ked/buffer"
+	"github.com/susji/ked/keys"
+)
+
+func ctrl(k tcell.Key) *tcell.EventKey {
+	return tcell.NewEventKey(k, 0, tcell.ModCtrl)
+}
+
+func TestKillChain(t *testing.T) {
+	s := tcell.NewSimulationScreen("UTF-8")
+	s.Init()
+	s.SetSize(40, 5)
+	e := NewWithScreen(s)
+	if err := e.keymap.Bind("Ctrl+X K", "close-buffer"); err != nil {
+		t.Fatal(err)
+	}
+	table := []struct {
+		name    string
+		between []*tcell.EventKey
+		chained bool
+	}{
+		{"consecutive", nil, true},
+		{"cancelled", []*tcell.EventKey{ctrl(tcell.KeyCtrlX), ctrl(tcell.KeyCtrlC)}, false},
+		{"undefined", []*tcell.EventKey{ctrl(tcell.KeyCtrlX), ctrl(tcell.KeyCtrlT)}, false},
+		{"unbound", []*tcell.EventKey{tcell.NewEventKey(tcell.KeyF12, 0, 0)}, false},
+		{"command", []*tcell.EventKey{ctrl(tcell.KeyCtrlE)}, false},
+	}
+	for _, entry := range table {
+		e.chain = chainnone
+		e.NewFromBuffer("", buffer.New([][]rune{[]rune("one"), []rune("two")}))
+		e.handlekey(ctrl(tcell.KeyCtrlK))
+		for _, ev := range entry.between {
+			e.handlekey(ev)
+		}
+		// The first kill leaves an empty line, so the second one
+		// kills the line break.
+		e.handlekey(ctrl(tcell.KeyCtrlK))
+		got := e.killring.Yank()
+		chained := len(got) == 2 && string(got[0]) == "one"
+		if chained != entry.chained {
+			t.Errorf("%s: want chained %t, got %q", entry.name, entry.chained, got)
+		}
+	}
+
+	// The chain is kept while a sequence is pending, and it ends when
+	// the sequence times out.
+	e.NewFromBuffer("", buffer.New([][]rune{[]rune("one")}))
+	e.handlekey(ctrl(tcell.KeyCtrlK))
+	e.handlekey(ctrl(tcell.KeyCtrlX))
+	if e.chain != chainkill {
+		t.Error("pending sequence should keep the chain")
+	}
+	e.timeoutchord(e.pendingid)
+	if e.chain != chainnone {
+		t.Error("timed out sequence should end the chain")
+	}
+}
+
+func TestCommands(t *testing.T) {
+	for name, cmd := range commands {
+		if cmd.fn == nil || len(cmd.doc) == 0 {
+			t.Errorf("%s: missing function or doc", name)
+		}
+		km := keys.NewKeymap()
+		if err := km.Bind("F12", name); err != nil {
+			t.Errorf("%s: cannot be bound: %v", name, err)
+		}
+		if got, _ := km.Lookup(keys.Sequence{{Key: tcell.KeyF12}}); got != name {
+			t.Errorf("%s: bound as %q", name, got)
+		}
+	}
+	e := New()
+	for _, dk := range defaultkeys {
+		if _, ok := commands[dk.command]; !ok {
+			t.Errorf("%s: unknown default command %q", dk.key, dk.command)
+		}
+		seq, err := keys.ParseSequence(dk.key)
+		if err != nil {
+			t.Errorf("%s: %v", dk.key, err)
+			continue
+		}
+		if got, _ := e.keymap.Lookup(seq); got != dk.command {
+			t.Errorf("%s: want %q, got %q", dk.key, dk.command, got)
+		}
+		if len(e.bindings(dk.command)) == 0 {
+			t.Errorf("%s: no bindings shown", dk.command)
+		}
+	}
+}
+
+func TestYankPopInGroup(t *testing.T) {
+	s := tcell.NewSimulationScreen("UTF-8")
+	s.Init()
+	s.SetSize(40, 5)
+	e := NewWithScreen(s)
+	e.NewFromBuffer("", buffer.New(nil))
+	e.killring.Push([][]rune{[]rune("one")})
+	e.killring.Push([][]rune{[]rune("two")})
+
+	b := e.buffers.Get(e.activebuf).Buffer
+	b.BeginGroup()
+	e.handlekey(tcell.NewEventKey(tcell.KeyRune, 'x', 0))
+	e.handlekey(ctrl(tcell.KeyCtrlY))
+	e.handlekey(tcell.NewEventKey(tcell.KeyRune, 'y', tcell.ModAlt))
+	b.EndGroup()
+	if got := string(b.Text()); got != "xone\n" {
+		t.Errorf("yank-pop should replace only the yank, got %q", got)
+	}
+}
@@ -5,6 +5,7 @@ package editor
 //     way to make those prettier.
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -12,19 +13,56 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/susji/ked/buffer"
+	"github.com/susji/ked/clipboard"
 	"github.com/susji/ked/config"
+	"github.com/susji/ked/grep"
 	"github.com/susji/ked/highlighting"
+	"github.com/susji/ked/keys"
+	"github.com/susji/ked/killring"
 	"github.com/susji/ked/library"
+	"github.com/susji/ked/recovery"
+	"github.com/susji/ked/search"
 	"github.com/susji/ked/ui/dialog"
 	"github.com/susji/ked/ui/editor/buffers"
 	"github.com/susji/ked/ui/fuzzyselect"
 	"github.com/susji/ked/ui/textentry"
 	"github.com/susji/ked/util"
+	"github.com/susji/ked/viewport"
+	"golang.org/x/text/encoding"
+)
+
+// diskcheck is posted periodically to check whether the files of
+// open buffers have been changed on disk.
+type diskcheck struct{}
+
+// autosave is posted periodically to journal the modified buffers.
+type autosave struct{}
+
+// clipboarderror is posted when copying to the clipboard has failed in
+// the background.
+type clipboarderror struct {
+	err error
+}
+
+// chordtimeout is posted when a pending key sequence has waited for its
+// next key for too long. Its value tells which sequence timed out.
+type chordtimeout int
+
+// Some commands behave differently when they are repeated. For
+// example, consecutive kills are accumulated into one kill ring
+// entry.
+const (
+	chainnone = iota
+	chainkill
+	chainyank
 )
 
 type Editor struct {
@@ -36,6 +74,25 @@ type Editor struct {
 	prevsearch    map[buffers.BufferId]string
 	bufpopularity map[buffers.BufferId]uint64
 	modified      map[buffers.BufferId]bool
+	matches       map[buffers.BufferId]*matchset
+
+	killring         *killring.KillRing
+	chain, prevchain int
+	clipboard        clipboard.Clipboard
+	recovery         *recovery.Recovery
+	searchopts       search.Options
+	history          *textentry.History
+	keymap           *keys.Keymap
+	pending          keys.Sequence
+	pendingid        int
+	quitting         bool
+	ms               *macroscreen
+	macro            keys.Sequence
+	macros           *keys.Macros
+	cmdstart         int
+	// queued are the status messages given before the screen was
+	// initialized, such as when opening files from the command line.
+	queued []string
 }
 
 func New() *Editor {
@@ -43,13 +100,58 @@ func New() *Editor {
 }
 
 func NewWithScreen(s tcell.Screen) *Editor {
-	return &Editor{
+	e := &Editor{
 		prevsearch:    map[buffers.BufferId]string{},
 		bufpopularity: map[buffers.BufferId]uint64{},
 		buffers:       buffers.New(),
 		modified:      map[buffers.BufferId]bool{},
-		s:             s,
+		matches:       map[buffers.BufferId]*matchset{},
+		killring:      killring.New(killring.DEFAULTSZ),
+		searchopts:    search.Options{SmartCase: true},
+		history:       textentry.NewHistory(""),
+		keymap:        defaultkeymap(),
+		macros:        keys.NewMacros(""),
+	}
+	e.clipboard = newclipboard(func(err error) {
+		e.s.PostEvent(tcell.NewEventInterrupt(clipboarderror{err}))
+	})
+	if s != nil {
+		e.setscreen(s)
 	}
+	return e
+}
+
+// EnableRecovery makes the editor journal its modified buffers into
+// dir and offer to restore journals left behind by crashed editors.
+func (e *Editor) EnableRecovery(dir string) {
+	e.recovery = recovery.New(dir)
+}
+
+// EnableHistory persists the answers given to prompts into path.
+func (e *Editor) EnableHistory(path string) {
+	if len(path) == 0 {
+		return
+	}
+	h, err := textentry.LoadHistory(path)
+	if err != nil {
+		log.Printf("[EnableHistory] %v\n", err)
+	}
+	e.history = h
+}
+
+// newclipboard returns the configured clipboard. The helper programs
+// are run in the background, and their errors are given to onerr.
+func newclipboard(onerr func(error)) clipboard.Clipboard {
+	cbs := []clipboard.Clipboard{}
+	if config.CLIPBOARD_OSC52 {
+		cbs = append(cbs, clipboard.NewOSC52(
+			clipboard.NewTTY(), config.CLIPBOARD_PASSTHROUGH))
+	}
+	if len(config.CLIPBOARD_COPY) > 0 || len(config.CLIPBOARD_PASTE) > 0 {
+		cbs = append(cbs, clipboard.NewAsync(clipboard.NewCommand(
+			config.CLIPBOARD_COPY, config.CLIPBOARD_PASTE), onerr))
+	}
+	return clipboard.NewMulti(cbs...)
 }
 
 func (e *Editor) NewBuffer(filepath string, r io.Reader) (buffers.BufferId, error) {
@@ -59,7 +161,59 @@ func (e *Editor) NewBuffer(filepath string, r io.Reader) (buffers.BufferId, erro
 		e.statusmsg(fmt.Sprintf("Buffer open failed: %v", err))
 		return 0, err
 	}
-	return e.NewFromBuffer(filepath, buf)
+	bid, err := e.NewFromBuffer(filepath, buf)
+	if err != nil {
+		return bid, err
+	}
+	if err := e.buffers.Get(bid).UpdateStamp(); err != nil {
+		log.Printf("[NewBuffer, stamp] %v\n", err)
+	}
+	return bid, nil
+}
+
+// OpenFile reads the contents of a file into a new buffer. Binary
+// files are refused, and files which are not valid UTF-8 are decoded
+// with the configured legacy encoding.
+func (e *Editor) OpenFile(filepath string, r io.Reader) (buffers.BufferId, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		log.Printf("[OpenFile] %v\n", err)
+		e.statusmsg(fmt.Sprintf("Buffer open failed: %v", err))
+		return 0, err
+	}
+	var enc encoding.Encoding
+	switch buffer.Sniff(data) {
+	case buffer.CONTENT_BINARY:
+		log.Printf("[OpenFile, binary] %q\n", filepath)
+		e.statusmsg(fmt.Sprintf("Refusing to open binary file: %s", filepath))
+		return 0, buffer.ErrorBinary
+	case buffer.CONTENT_LEGACY:
+		enc, err = buffer.LookupEncoding(config.LEGACY_ENCODING)
+		if err != nil {
+			log.Printf("[OpenFile, encoding] %v\n", err)
+			e.statusmsg(fmt.Sprintf("Buffer open failed: %v", err))
+			return 0, err
+		}
+		log.Printf("[OpenFile, legacy] %q -> %s\n", filepath, config.LEGACY_ENCODING)
+	}
+	buf, err := buffer.NewFromReaderEncoding(bytes.NewReader(data), enc)
+	if err != nil {
+		log.Printf("[OpenFile] %v\n", err)
+		e.statusmsg(fmt.Sprintf("Buffer open failed: %v", err))
+		return 0, err
+	}
+	bid, err := e.NewFromBuffer(filepath, buf)
+	if err != nil {
+		return bid, err
+	}
+	if err := e.buffers.Get(bid).UpdateStamp(); err != nil {
+		log.Printf("[OpenFile, stamp] %v\n", err)
+	}
+	if enc != nil {
+		e.statusmsg(fmt.Sprintf(
+			"Not UTF-8, decoded as %s: %s", config.LEGACY_ENCODING, filepath))
+	}
+	return bid, nil
 }
 
 func (e *Editor) setactivebuf(bid buffers.BufferId) {
@@ -106,17 +260,23 @@ func (e *Editor) NewFromBuffer(filepath string, buf *buffer.Buffer) (buffers.Buf
 }
 
 func (e *Editor) askyesno(prompt string) bool {
+	return e.askchoice(prompt, "yn") == 'y'
+}
+
+// askchoice waits until the user answers with one of the runes in
+// choices. Cancelling is answered with zero.
+func (e *Editor) askchoice(prompt, choices string) rune {
 	d := dialog.New(prompt)
 	_, h := e.s.Size()
 	for {
 		key, r := d.Ask(e.s, 0, h-1)
-		log.Printf("[askyesno] %s %c\n", tcell.KeyNames[key], r)
+		log.Printf("[askchoice] %s %c\n", tcell.KeyNames[key], r)
+		r = unicode.ToLower(r)
 		switch {
-		case key == tcell.KeyRune && (r == 'y' || r == 'Y'):
-			return true
-		case (key == tcell.KeyRune && (r == 'n' || r == 'N')) ||
-			key == tcell.KeyCtrlC:
-			return false
+		case key == tcell.KeyRune && strings.ContainsRune(choices, r):
+			return r
+		case key == tcell.KeyCtrlC:
+			return 0
 		}
 	}
 }
@@ -144,8 +304,12 @@ func (e *Editor) closeactivebuffer(force bool) bool {
 
 func (e *Editor) closebuffer(bid buffers.BufferId) bool {
 	log.Printf("[closebuffer] %d\n", bid)
+	if err := e.recovery.Remove(uint32(bid)); err != nil {
+		log.Printf("[closebuffer, journal] %v\n", err)
+	}
 	e.buffers.Close(bid)
 	delete(e.bufpopularity, bid)
+	delete(e.matches, bid)
 	waslast := e.buffers.Len() == 0
 	if waslast {
 		e.NewFromBuffer("", buffer.New(nil))
@@ -154,17 +318,33 @@ func (e *Editor) closebuffer(bid buffers.BufferId) bool {
 }
 
 func (e *Editor) initscreen() error {
-	var err error
-	e.s, err = tcell.NewScreen()
+	s, err := tcell.NewScreen()
 	if err != nil {
 		return err
 	}
+	e.setscreen(s)
 	if err := e.s.Init(); err != nil {
 		return err
 	}
+	e.s.EnablePaste()
 	return nil
 }
 
+// newentry returns a text entry, which remembers its answers in the
+// history of kind and redraws the editor when the screen is resized.
+func (e *Editor) newentry(defval, prompt string, maxlen int, kind string) *textentry.TextEntry {
+	return textentry.
+		New(defval, prompt, maxlen).
+		SetHistory(e.history, kind).
+		SetRedraw(e.redraw)
+}
+
+func (e *Editor) redraw() {
+	e.s.Clear()
+	e.drawactivebuf()
+	e.drawstatusline()
+}
+
 func (e *Editor) drawactivebuf() {
 	eb := e.buffers.Get(e.activebuf)
 
@@ -176,7 +356,15 @@ func (e *Editor) drawactivebuf() {
 			e.buffers.All()))
 	}
 	w, h := e.s.Size()
-	rend := eb.Viewport.Render(w, h-1, eb.CursorLine(), eb.CursorCol(), eb.Hilite)
+	decos := []viewport.Decorator{}
+	if ms, ok := e.matches[eb.Id()]; ok {
+		decos = append(decos, ms.decorator())
+	}
+	if deco := regiondecorator(eb); deco != nil {
+		decos = append(decos, deco)
+	}
+	rend := eb.Viewport.Render(
+		w, h-1, eb.CursorLine(), eb.CursorCol(), eb.Hilite, decos...)
 	col := 0
 	lineno := 0
 	for h > 0 && rend.Scan() {
@@ -193,6 +381,20 @@ func (e *Editor) drawactivebuf() {
 	e.s.ShowCursor(vx, vy)
 }
 
+func regiondecorator(eb *buffers.EditorBuffer) viewport.Decorator {
+	startline, startcol, endline, endcol, ok := eb.Region()
+	if !ok {
+		return nil
+	}
+	return func(lineno, col int, st tcell.Style) tcell.Style {
+		if (lineno > startline || (lineno == startline && col >= startcol)) &&
+			(lineno < endline || (lineno == endline && col < endcol)) {
+			return st.Reverse(true)
+		}
+		return st
+	}
+}
+
 func (e *Editor) insertrune(r rune) {
 	eb := e.buffers.Get(e.activebuf)
 	eb.Update(
@@ -291,6 +493,11 @@ func (e *Editor) moveline(start bool) {
 
 func (e *Editor) setmodified(status bool) {
 	e.modified[e.activebuf] = status
+	if status {
+		// Persisting search matches are shown until the
+		// buffer is edited.
+		delete(e.matches, e.activebuf)
+	}
 }
 
 func (e *Editor) ismodified() bool {
@@ -302,8 +509,8 @@ func (e *Editor) savebuffer() {
 	log.Printf("[savebuffer] %q\n", eb.Filepath)
 
 	_, h := e.s.Size()
-	fp, err := textentry.
-		New(eb.Filepath, "Save: ", 512).
+	fp, err := e.
+		newentry(eb.Filepath, "Save: ", 512, "filepath").
 		Ask(e.s, 0, h-1)
 	if err != nil {
 		log.Println("[savebuffer, error-ask] ", err)
@@ -327,6 +534,27 @@ func (e *Editor) savebuffer() {
 		}
 	}
 
+	if abspath == eb.Filepath {
+		if changed, err := eb.CheckStamp(); err != nil {
+			log.Println("[savebuffer, check stamp] ", err)
+		} else if changed {
+			switch e.askchoice(fmt.Sprintf(
+				"%s changed on disk: [o]verwrite, [r]eload, [d]iff, [c]ancel?",
+				filepath.Base(abspath)), "ordc") {
+			case 'o':
+				log.Println("[savebuffer, overwriting]")
+			case 'r':
+				e.reloadbuffer(abspath)
+				return
+			case 'd':
+				e.diffbuffer(abspath)
+				return
+			default:
+				return
+			}
+		}
+	}
+
 	log.Println("[savebuffer, abs] ", abspath)
 	if err := eb.Buffer.Save(abspath); err != nil {
 		log.Println("[savebuffer] failed: ", err)
@@ -335,6 +563,12 @@ func (e *Editor) savebuffer() {
 	}
 	eb.Filepath = abspath
 	e.setmodified(false)
+	if err := eb.UpdateStamp(); err != nil {
+		log.Println("[savebuffer, stamp] ", err)
+	}
+	if err := e.recovery.Remove(uint32(e.activebuf)); err != nil {
+		log.Println("[savebuffer, journal] ", err)
+	}
 
 	ec := config.GetEditorConfig(eb.Filepath)
 	eb.Buffer.TabSize = ec.TabSize
@@ -361,54 +595,175 @@ func (e *Editor) execandreload(abspath string, cmd []string) {
 		e.statusmsg(fmt.Sprintf("Hook failed: %v", err))
 		return
 	}
+	e.reloadbuffer(abspath)
+}
+
+// reloadbuffer replaces the contents of the active buffer with the
+// contents of the file.
+func (e *Editor) reloadbuffer(abspath string) {
 	f, err := os.Open(abspath)
 	if err != nil {
-		log.Printf("[execandreload, reopen error] %v\n", err)
+		log.Printf("[reloadbuffer, reopen error] %v\n", err)
 		e.statusmsg(fmt.Sprintf("Reopening buffer failed: %v", err))
 		return
 	}
 	defer f.Close()
 
-	oldbuf := e.buffers.Get(e.activebuf)
-	oldviewportstart := oldbuf.Viewport.Start()
-	oldcursorline, oldcursorcol := oldbuf.CursorLine(), oldbuf.CursorCol()
+	// Reloading is done in-place so the changes on disk become one
+	// undoable modification of the buffer.
+	eb := e.buffers.Get(e.activebuf)
+	log.Println("[reloadbuffer, reopened] ", abspath)
+	if err := eb.Buffer.Reload(f); err != nil {
+		log.Printf("[reloadbuffer, reload error] %v\n", err)
+		e.statusmsg(fmt.Sprintf("Reloading buffer failed: %v", err))
+		return
+	}
+	e.setmodified(false)
+	if err := eb.UpdateStamp(); err != nil {
+		log.Printf("[reloadbuffer, stamp] %v\n", err)
+	}
+	e.sethighlighting()
+	//
+	// Make sure old cursor snaps into the reloaded buffer.
+	//
+	cursorline, cursorcol := eb.Cursor()
+	if cursorline > eb.Buffer.Lines()-1 {
+		cursorline = eb.Buffer.Lines() - 1
+	}
+	if cursorcol > eb.Buffer.LineLength(cursorline) {
+		cursorcol = eb.Buffer.LineLength(cursorline)
+	}
+	eb.SetCursor(cursorline, cursorcol)
+	if start := eb.Viewport.Start(); start > eb.Buffer.Lines()-1 {
+		eb.Viewport.SetTeleported(eb.Buffer.Lines() - 1)
+	}
+}
 
-	log.Println("[execandreload, reopened] ", abspath)
-	newbid, err := e.NewBuffer(abspath, f)
+// diffbuffer opens a new buffer displaying the differences between
+// the file and the active buffer.
+func (e *Editor) diffbuffer(abspath string) {
+	eb := e.buffers.Get(e.activebuf)
+	data, err := eb.Buffer.Bytes()
 	if err != nil {
-		log.Printf("[execandreload, newbuffer error] %v\n", err)
+		log.Printf("[diffbuffer, bytes error] %v\n", err)
+		e.statusmsg(fmt.Sprintf("Diff failed: %v", err))
 		return
 	}
-	// Now that the new buffer was opened successfully, we can get
-	// rid of the old one.
-	e.closebuffer(oldbuf.Id())
-	e.setactivebuf(newbid)
+	c := exec.Command("diff", "-u", abspath, "-")
+	c.Stdin = bytes.NewReader(data)
+	out, err := c.Output()
+	// diff exits with status 1 if the inputs differ.
+	var exiterr *exec.ExitError
+	if err != nil && !(errors.As(err, &exiterr) && exiterr.ExitCode() == 1) {
+		log.Printf("[diffbuffer, exec error] %v\n", err)
+		e.statusmsg(fmt.Sprintf("Diff failed: %v", err))
+		return
+	}
+	e.OpenFile("", bytes.NewReader(out))
+}
 
-	newbuf := e.buffers.Get(newbid)
-	//
-	// Make sure old cursor snaps into new buffer.
-	//
-	newcursorline := oldcursorline
-	newcursorcol := oldcursorcol
-	if newcursorline > newbuf.Buffer.Lines()-1 {
-		newcursorline = newbuf.Buffer.Lines() - 1
+// checkdisk marks the buffers whose files have been changed on disk.
+func (e *Editor) checkdisk() {
+	for _, eb := range e.buffers.All() {
+		if _, err := eb.CheckStamp(); err != nil {
+			log.Printf("[checkdisk] %q: %v\n", eb.Filepath, err)
+		}
 	}
-	if newcursorcol > newbuf.Buffer.LineLength(newcursorline) {
-		newcursorcol = newbuf.Buffer.LineLength(newcursorline)
+}
+
+// journal writes the modified buffers into recovery journals and
+// removes the journals of unmodified ones.
+func (e *Editor) journal() {
+	for bid, eb := range e.buffers.All() {
+		var err error
+		if e.modified[bid] {
+			err = e.recovery.Write(
+				uint32(bid), eb.Filepath, eb.Buffer.Format.String(), eb.Buffer.Text())
+		} else {
+			err = e.recovery.Remove(uint32(bid))
+		}
+		if err != nil {
+			log.Printf("[journal] %d: %v\n", bid, err)
+		}
 	}
-	if oldviewportstart > newbuf.Buffer.Lines()-1 {
-		oldviewportstart = newbuf.Buffer.Lines() - 1
+}
+
+// recover offers to restore the journals left behind by editors
+// which did not exit cleanly.
+func (e *Editor) recover() {
+	journals, err := e.recovery.Orphans()
+	if err != nil {
+		log.Printf("[recover] %v\n", err)
+		e.statusmsg(fmt.Sprintf("Cannot check recovery journals: %v", err))
+		return
 	}
+	for _, j := range journals {
+		name := j.Filepath
+		if len(name) == 0 {
+			name = "<unnamed>"
+		}
+		e.s.Clear()
+		e.drawactivebuf()
+		e.s.Show()
+		switch e.askchoice(fmt.Sprintf(
+			"Unsaved %s from %s: [r]estore, [d]iscard, [k]eep?",
+			name, j.Saved.Format("2006-01-02 15:04")), "rdk") {
+		case 'r':
+			if err := e.restore(j); err != nil {
+				continue
+			}
+			e.setmodified(true)
+			// The restored buffer is journaled by us from now on.
+			if err := j.Discard(); err != nil {
+				log.Printf("[recover, discard] %v\n", err)
+			}
+		case 'd':
+			if err := j.Discard(); err != nil {
+				log.Printf("[recover, discard] %v\n", err)
+			}
+		}
+	}
+}
 
-	newbuf.SetCursor(newcursorline, newcursorcol)
-	newbuf.Viewport.SetTeleported(oldviewportstart)
+// restore opens the buffer in journal j with the format of its file.
+func (e *Editor) restore(j *recovery.Journal) error {
+	format, err := buffer.ParseFormat(j.Format)
+	if err != nil {
+		log.Printf("[restore] %v\n", err)
+		e.statusmsg(fmt.Sprintf("Buffer restore failed: %v", err))
+		return err
+	}
+	buf, err := buffer.NewFromReader(bytes.NewReader(j.Data))
+	if err != nil {
+		log.Printf("[restore] %v\n", err)
+		e.statusmsg(fmt.Sprintf("Buffer restore failed: %v", err))
+		return err
+	}
+	buf.Format = format
+	bid, err := e.NewFromBuffer(j.Filepath, buf)
+	if err != nil {
+		return err
+	}
+	if err := e.buffers.Get(bid).UpdateStamp(); err != nil {
+		log.Printf("[restore, stamp] %v\n", err)
+	}
+	return nil
+}
+
+// ticker posts data periodically into the event loop. This way all
+// buffer handling happens within the event loop.
+func (e *Editor) ticker(interval time.Duration, data interface{}) {
+	t := time.NewTicker(interval)
+	for range t.C {
+		e.s.PostEvent(tcell.NewEventInterrupt(data))
+	}
 }
 
 func (e *Editor) jumpline() {
 	eb := e.buffers.Get(e.activebuf)
 	_, h := e.s.Size()
-	linenoraw, err := textentry.
-		New("", "Line: ", 12).
+	linenoraw, err := e.
+		newentry("", "Line: ", 12, "line").
 		Ask(e.s, 0, h-1)
 	if err != nil {
 		log.Println("[jumpline, error-ask] ", err)
@@ -441,10 +796,114 @@ func (e *Editor) delline() {
 		eb.Update(eb.Buffer.Perform(buffer.NewDelLine(eb.CursorLine())))
 		eb.Hilite.DeleteLine(eb.CursorLine() + 1)
 		e.highlightline(eb.CursorLine())
+		e.kill([][]rune{{}, {}})
 		return
 	}
+	killed := eb.Buffer.GetLine(eb.CursorLine())[eb.CursorCol():]
 	eb.Update(eb.Buffer.Perform(buffer.NewDelLineContent(eb.CursorLine(), eb.CursorCol())))
 	e.highlightline(eb.CursorLine())
+	e.kill([][]rune{killed})
+}
+
+// kill stores text into the kill ring. Consecutive kills are joined
+// together.
+func (e *Editor) kill(text [][]rune) {
+	if e.prevchain == chainkill {
+		e.killring.Append(text)
+	} else {
+		e.killring.Push(text)
+	}
+	e.chain = chainkill
+	e.toclipboard(e.killring.Yank())
+}
+
+func (e *Editor) toclipboard(text [][]rune) {
+	lines := make([]string, len(text))
+	for i, line := range text {
+		lines[i] = string(line)
+	}
+	if err := e.clipboard.Copy(strings.Join(lines, "\n")); err != nil {
+		log.Printf("[toclipboard] %v\n", err)
+		e.statusmsg(fmt.Sprintf("Copying to clipboard failed: %v", err))
+	}
+}
+
+func (e *Editor) paste() {
+	raw, err := e.clipboard.Paste()
+	if errors.Is(err, clipboard.ErrorNoPaste) {
+		e.statusmsg("No clipboard paste command configured")
+		return
+	} else if err != nil {
+		log.Printf("[paste] %v\n", err)
+		e.statusmsg(fmt.Sprintf("Pasting from clipboard failed: %v", err))
+		return
+	}
+	text := [][]rune{}
+	for _, line := range strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n") {
+		text = append(text, []rune(line))
+	}
+	e.yank(text)
+}
+
+func (e *Editor) setmark() {
+	eb := e.buffers.Get(e.activebuf)
+	if eb.Marked() {
+		eb.ClearMark()
+		return
+	}
+	eb.SetMark()
+}
+
+func (e *Editor) copyregion() {
+	eb := e.buffers.Get(e.activebuf)
+	startline, startcol, endline, endcol, ok := eb.Region()
+	if !ok {
+		return
+	}
+	text := eb.Buffer.Region(startline, startcol, endline, endcol)
+	e.killring.Push(text)
+	e.toclipboard(text)
+	eb.ClearMark()
+}
+
+func (e *Editor) cutregion() {
+	eb := e.buffers.Get(e.activebuf)
+	startline, startcol, endline, endcol, ok := eb.Region()
+	if !ok {
+		return
+	}
+	text := eb.Buffer.Region(startline, startcol, endline, endcol)
+	eb.Update(eb.Buffer.Perform(
+		buffer.NewDelRegion(startline, startcol, endline, endcol)))
+	eb.ClearMark()
+	e.kill(text)
+	e.setmodified(true)
+	e.sethighlighting()
+}
+
+func (e *Editor) yank(text [][]rune) {
+	if len(text) == 0 {
+		return
+	}
+	eb := e.buffers.Get(e.activebuf)
+	eb.Update(eb.Buffer.Perform(buffer.NewYank(eb.CursorLine(), eb.CursorCol(), text)))
+	eb.ClearMark()
+	e.chain = chainyank
+	e.setmodified(true)
+	e.sethighlighting()
+}
+
+// yankpop replaces the just-yanked text with the previous kill ring
+// entry.
+func (e *Editor) yankpop() {
+	if e.prevchain != chainyank {
+		return
+	}
+	eb := e.buffers.Get(e.activebuf)
+	if res := eb.Buffer.UndoYank(); res != nil {
+		eb.Update(*res)
+	}
+	e.yank(e.killring.Rotate())
 }
 
 func (e *Editor) jumpword(left bool) {
@@ -452,37 +911,246 @@ func (e *Editor) jumpword(left bool) {
 	eb.SetCursor(eb.Buffer.JumpWord(eb.CursorLine(), eb.CursorCol(), left))
 }
 
-func (e *Editor) replace() {
+var (
+	errtoggleregexp = errors.New("toggle regexp")
+	errtogglecase   = errors.New("toggle case")
+	errtoggleword   = errors.New("toggle whole word")
+)
+
+// searchprompt decorates prompt with the active search options and
+// info.
+func (e *Editor) searchprompt(prompt, info string) string {
+	flags := []string{}
+	if e.searchopts.Regexp {
+		flags = append(flags, "regexp")
+	}
+	switch {
+	case e.searchopts.CaseSensitive:
+		flags = append(flags, "case")
+	case e.searchopts.SmartCase:
+		flags = append(flags, "smartcase")
+	}
+	if e.searchopts.WholeWord {
+		flags = append(flags, "word")
+	}
+	if len(flags) > 0 {
+		prompt = fmt.Sprintf("%s (%s)", prompt, strings.Join(flags, ", "))
+	}
+	if len(info) > 0 {
+		prompt += " " + info
+	}
+	return prompt + ": "
+}
+
+// searchentry returns a text entry with bindings for toggling the
+// search options.
+func (e *Editor) searchentry(prompt string, bid buffers.BufferId) *textentry.TextEntry {
+	return e.
+		newentry(e.prevsearch[bid], e.searchprompt(prompt, ""), 256, "search").
+		AddAltBinding('r', errtoggleregexp).
+		AddAltBinding('c', errtogglecase).
+		AddAltBinding('w', errtoggleword)
+}
+
+// togglesearch changes the search option matching err. Case matching
+// cycles from insensitive to smart-case to sensitive.
+func (e *Editor) togglesearch(err error) bool {
+	switch {
+	case errors.Is(err, errtoggleregexp):
+		e.searchopts.Regexp = !e.searchopts.Regexp
+	case errors.Is(err, errtogglecase):
+		switch {
+		case e.searchopts.CaseSensitive:
+			e.searchopts.CaseSensitive = false
+			e.searchopts.SmartCase = false
+		case e.searchopts.SmartCase:
+			e.searchopts.CaseSensitive = true
+		default:
+			e.searchopts.SmartCase = true
+		}
+	case errors.Is(err, errtoggleword):
+		e.searchopts.WholeWord = !e.searchopts.WholeWord
+	default:
+		return false
+	}
+	log.Printf("[togglesearch] %+v\n", e.searchopts)
+	return true
+}
+
+// compilesearch compiles term with the active search options and
+// reports invalid terms.
+func (e *Editor) compilesearch(term []rune) (*search.Matcher, bool) {
+	m, err := search.Compile(string(term), e.searchopts)
+	if err != nil {
+		log.Printf("[compilesearch] %v\n", err)
+		e.statusmsg(fmt.Sprintf("Invalid search: %v", err))
+		return nil, false
+	}
+	return m, true
+}
+
+// askreplace asks for the term to replace and its replacement.
+func (e *Editor) askreplace(prompt string) (*search.Matcher, string, bool) {
 	eb := e.buffers.Get(e.activebuf)
 	_, h := e.s.Size()
-	from, err := textentry.
-		New(e.prevsearch[eb.Id()], "Replace: ", 256).
+	te := e.searchentry(prompt, eb.Id())
+	var from []rune
+	for {
+		var err error
+		from, err = te.Ask(e.s, 0, h-1)
+		if e.togglesearch(err) {
+			te.SetPrompt(e.searchprompt(prompt, ""))
+			continue
+		}
+		if err != nil || len(from) == 0 {
+			return nil, "", false
+		}
+		break
+	}
+	m, ok := e.compilesearch(from)
+	if !ok {
+		return nil, "", false
+	}
+
+	to, err := e.
+		newentry(e.prevsearch[eb.Id()], "... with: ", 256, "replace").
 		Ask(e.s, 0, h-1)
-	if err != nil || len(from) == 0 {
-		return
+	if err != nil {
+		return nil, "", false
 	}
+	log.Printf("[askreplace] %q -> %q\n", string(from), string(to))
+	return m, string(to), true
+}
 
-	to, err := textentry.
-		New(e.prevsearch[eb.Id()], "... with: ", 256).
+// replacelimits returns the region if it is set. Otherwise we ask for
+// a range of lines, and an empty answer means from the cursor onwards.
+func (e *Editor) replacelimits() (*buffer.SearchLimit, bool) {
+	eb := e.buffers.Get(e.activebuf)
+	if startline, startcol, endline, endcol, ok := eb.Region(); ok {
+		return &buffer.SearchLimit{
+			StartLineno: startline,
+			StartCol:    startcol,
+			EndLineno:   endline,
+			EndCol:      endcol,
+		}, true
+	}
+	_, h := e.s.Size()
+	raw, err := e.
+		newentry("", "... in lines (a,b or %): ", 32, "range").
 		Ask(e.s, 0, h-1)
 	if err != nil {
+		return nil, false
+	}
+	if len(raw) == 0 {
+		return &buffer.SearchLimit{
+			StartLineno: eb.CursorLine(),
+			StartCol:    eb.CursorCol(),
+			EndLineno:   eb.Buffer.Lines() - 1,
+			EndCol:      eb.Buffer.LineLength(eb.Buffer.Lines() - 1),
+		}, true
+	}
+	start, end, err := util.ParseLineRange(string(raw), eb.Buffer.Lines())
+	if err != nil {
+		e.statusmsg(fmt.Sprintf("%v", err))
+		return nil, false
+	}
+	return &buffer.SearchLimit{
+		StartLineno: start,
+		EndLineno:   end,
+		EndCol:      eb.Buffer.LineLength(end),
+	}, true
+}
+
+func (e *Editor) replace() {
+	eb := e.buffers.Get(e.activebuf)
+	m, to, ok := e.askreplace("Replace")
+	if !ok {
+		return
+	}
+	limits, ok := e.replacelimits()
+	if !ok {
+		return
+	}
+	log.Printf("[replace, limits] %#v\n", limits)
+	n, last := eb.Buffer.ReplaceAll(m, to, limits)
+	if n > 0 {
+		log.Printf("[replace, found] %d, last %+v\n", n, last)
+		eb.ClearMark()
+		eb.SetCursor(last.StartLineno, last.StartCol)
+		eb.Viewport.SetTeleported(eb.CursorLine())
+		e.setmodified(true)
+		e.sethighlighting()
+		e.s.Clear()
+		e.drawactivebuf()
+	}
+	e.statusmsg(fmt.Sprintf("Replaced %d occurrences", n))
+}
+
+// queryreplace asks whether to replace each match from the cursor
+// onwards. The whole session is undone as one.
+func (e *Editor) queryreplace() {
+	eb := e.buffers.Get(e.activebuf)
+	m, to, ok := e.askreplace("Query replace")
+	if !ok {
 		return
 	}
+	eb.Buffer.BeginGroup()
+	defer eb.Buffer.EndGroup()
+	defer delete(e.matches, eb.Id())
 
-	log.Printf("[replace] %q -> %q\n", string(from), string(to))
-	limits := &buffer.SearchLimit{
-		StartLineno: eb.CursorLine(),
-		StartCol:    eb.CursorCol(),
-		EndLineno:   eb.Buffer.Lines() - 1,
-		EndCol:      eb.Buffer.LineLength(eb.Buffer.Lines() - 1),
-	}
-	log.Printf("[search, limits] %#v\n", limits)
-	if lineno, col := eb.Buffer.ReplaceRange(from, to, limits); lineno != -1 && col != -1 {
-		log.Printf("[replace, found] (%d, %d)\n", lineno, col)
-		eb.SetCursor(lineno, col)
+	n := 0
+	lineno, col := eb.Cursor()
+query:
+	for {
+		limits := &buffer.SearchLimit{
+			StartLineno: lineno,
+			StartCol:    col,
+			EndLineno:   eb.Buffer.Lines() - 1,
+			EndCol:      eb.Buffer.LineLength(eb.Buffer.Lines() - 1),
+		}
+		match, found := eb.Buffer.Find(m, limits)
+		if !found {
+			break
+		}
+		eb.SetCursor(match.StartLineno, match.StartCol)
+		eb.Viewport.SetTeleported(eb.CursorLine())
+		e.matches[eb.Id()] = newmatchset([]buffer.Match{match}, 0)
+		e.s.Clear()
+		e.drawactivebuf()
+		e.s.Show()
+
+		switch e.askchoice("Replace [y]es, [n]o, [a]ll, [q]uit?", "ynaq") {
+		case 'y':
+			match, _ = eb.Buffer.ReplaceFirst(m, to, limits)
+			n++
+		case 'n':
+		case 'a':
+			rest, last := eb.Buffer.ReplaceAll(m, to, limits)
+			n += rest
+			eb.SetCursor(last.StartLineno, last.StartCol)
+			break query
+		default:
+			break query
+		}
+
+		// Continue after the match and step over empty ones.
+		lineno, col = match.EndLineno, match.EndCol
+		if match.StartLineno == match.EndLineno && match.StartCol == match.EndCol {
+			switch {
+			case col < eb.Buffer.LineLength(lineno):
+				col++
+			case lineno < eb.Buffer.Lines()-1:
+				lineno, col = lineno+1, 0
+			default:
+				break query
+			}
+		}
+	}
+	log.Printf("[queryreplace] replaced %d\n", n)
+	if n > 0 {
 		eb.Viewport.SetTeleported(eb.CursorLine())
 		e.setmodified(true)
-		e.highlightline(lineno)
+		e.sethighlighting()
 	}
 }
 
@@ -495,18 +1163,40 @@ func (e *Editor) search() {
 
 	_, h := e.s.Size()
 	nexterr := errors.New("next term")
-	te := textentry.
-		New(e.prevsearch[eb.Id()], "Search: ", 256).
-		AddBinding(tcell.KeyCtrlS, nexterr)
-	looping := true
-	prevcol := eb.CursorCol()
-	for looping {
+	preverr := errors.New("previous term")
+	te := e.searchentry("Search", eb.Id()).
+		AddBinding(tcell.KeyCtrlS, nexterr).
+		AddBinding(tcell.KeyCtrlR, preverr)
+	defer func() {
+		if !config.PERSIST_MATCHES {
+			delete(e.matches, eb.Id())
+		} else if ms, ok := e.matches[eb.Id()]; ok {
+			ms.current = -1
+		}
+	}()
+	// The search continues from the latest match. If the term or
+	// the options change, the latest match may match again.
+	fromlineno, fromcol := eb.Cursor()
+	var prevterm string
+	var prevopts search.Options
+	found := false
+	info := ""
+	for {
 		term, err := te.Ask(e.s, 0, h-1)
+		backward := false
 		switch {
 		case err == nil:
-			looping = false
+			if found && string(term) == prevterm && e.searchopts == prevopts {
+				return
+			}
 		case errors.Is(err, nexterr):
 			log.Printf("[search] got next for %q\n", string(term))
+		case errors.Is(err, preverr):
+			log.Printf("[search] got previous for %q\n", string(term))
+			backward = true
+		case e.togglesearch(err):
+			te.SetPrompt(e.searchprompt("Search", info))
+			continue
 		case errors.Is(err, textentry.ErrorCancelled):
 			return
 		default:
@@ -515,27 +1205,44 @@ func (e *Editor) search() {
 			return
 		}
 
+		m, ok := e.compilesearch(term)
+		if !ok {
+			continue
+		}
+		inclusive := !found || string(term) != prevterm || e.searchopts != prevopts
+		prevterm, prevopts = string(term), e.searchopts
 		limits := &buffer.SearchLimit{
-			StartLineno: eb.CursorLine(),
-			StartCol:    prevcol,
-			EndLineno:   eb.Buffer.Lines() - 1,
-			EndCol:      eb.Buffer.LineLength(eb.Buffer.Lines() - 1),
+			EndLineno: eb.Buffer.Lines() - 1,
+			EndCol:    eb.Buffer.LineLength(eb.Buffer.Lines() - 1),
 		}
-		log.Printf("[search, limits] %#v\n", limits)
-		if lineno, col := eb.Buffer.SearchRange(term, limits); lineno != -1 && col != -1 {
-			log.Printf("[search, found] (%d, %d)\n", lineno, col)
-			eb.SetCursor(lineno, col)
+		matches := eb.Buffer.FindAll(m, limits)
+		i, wrapped := buffer.Nearest(matches, fromlineno, fromcol, inclusive, backward)
+		e.matches[eb.Id()] = newmatchset(matches, i)
+		if i < 0 {
+			found = false
+			info = "[no matches]"
+		} else {
+			match := matches[i]
+			log.Printf("[search, found] %d/%d %+v\n", i+1, len(matches), match)
+			found = true
+			info = fmt.Sprintf("%d/%d", i+1, len(matches))
+			if wrapped {
+				info = "[wrapped] " + info
+			}
+			fromlineno, fromcol = match.StartLineno, match.StartCol
+			eb.SetCursor(match.StartLineno, match.StartCol)
 			eb.Viewport.SetTeleported(eb.CursorLine())
 			e.prevsearch[eb.Id()] = string(term)
-			e.s.Clear()
-			e.drawactivebuf()
-			e.s.Show()
-
-			prevcol = eb.CursorCol() + len(term)
-			linelen := eb.Buffer.LineLength(lineno)
-			if prevcol >= linelen {
-				prevcol = linelen - 1
+		}
+		e.s.Clear()
+		e.drawactivebuf()
+		e.s.Show()
+		te.SetPrompt(e.searchprompt("Search", info))
+		if err == nil {
+			if wrapped || !found {
+				e.statusmsg("Search " + info)
 			}
+			return
 		}
 	}
 }
@@ -543,7 +1250,8 @@ func (e *Editor) search() {
 func (e *Editor) statusmsg(msg string) {
 	log.Println("[drawstatusmsg] ", msg)
 	if e.s == nil {
-		log.Println("[drawstatusmsg] screen not yet initialized")
+		log.Println("[drawstatusmsg] screen not yet initialized, queued")
+		e.queued = append(e.queued, msg)
 		return
 	}
 	w, h := e.s.Size()
@@ -569,14 +1277,31 @@ func (e *Editor) drawstatusline() {
 	var modified rune
 	if e.ismodified() {
 		modified = '*'
+	} else if eb.ReadOnly {
+		modified = '%'
 	} else {
 		modified = ' '
 	}
+	ondisk := ' '
+	if eb.ChangedOnDisk() {
+		ondisk = '!'
+	}
+
+	format := eb.Buffer.Format.String()
 
-	fn = string(util.TruncateLine([]rune(fn), w-20, ':'))
+	chord := ""
+	if e.ms.recording {
+		chord = " [recording]"
+	}
+	if len(e.pending) > 0 {
+		chord += fmt.Sprintf(" %s-", e.pending)
+	}
+
+	fn = string(util.TruncateLine([]rune(fn), w-22-len(format)-len(chord), ':'))
 	line := []rune(
 		fmt.Sprintf(
-			"[%03d] %3d, %2d: %c %s", e.activebuf, lineno, col, modified, fn))
+			"[%03d] %3d, %2d: %c%c %s %s%s",
+			e.activebuf, lineno, col, modified, ondisk, format, fn, chord))
 	for i, r := range line {
 		e.s.SetContent(i, h-1, r, nil, config.STYLE_DEFAULT)
 		if i > w {
@@ -585,6 +1310,19 @@ func (e *Editor) drawstatusline() {
 	}
 }
 
+// toggleeol switches the line endings of the active buffer between LF
+// and CRLF.
+func (e *Editor) toggleeol() {
+	eb := e.buffers.Get(e.activebuf)
+	if eb.Buffer.Format.EOL == buffer.EOL_LF {
+		eb.Buffer.Format.EOL = buffer.EOL_CRLF
+	} else {
+		eb.Buffer.Format.EOL = buffer.EOL_LF
+	}
+	e.setmodified(true)
+	e.statusmsg(fmt.Sprintf("Line endings are now %s", eb.Buffer.Format.EOL))
+}
+
 func (e *Editor) jumpempty(up bool) {
 	eb := e.buffers.Get(e.activebuf)
 	defer func() {
@@ -617,6 +1355,17 @@ func (e *Editor) undo() {
 	}
 }
 
+func (e *Editor) redo() {
+	eb := e.buffers.Get(e.activebuf)
+	if res := eb.Buffer.RedoModification(); res != nil {
+		eb.Update(*res)
+		eb.Viewport.SetTeleported(eb.CursorLine())
+		// XXX Similarly to undo, we reanalyse the whole file.
+		e.sethighlighting()
+		e.setmodified(true)
+	}
+}
+
 func (e *Editor) backtab() {
 	eb := e.buffers.Get(e.activebuf)
 	len0 := eb.Buffer.LineLength(eb.CursorLine())
@@ -629,7 +1378,8 @@ func (e *Editor) backtab() {
 	}
 }
 
-func (e *Editor) openbuffer() {
+// askrootdir asks for the directory to find files from.
+func (e *Editor) askrootdir() (string, bool) {
 	var rootdir string
 
 	if len(e.prevopendir) > 0 {
@@ -645,30 +1395,38 @@ func (e *Editor) openbuffer() {
 		}
 	}
 
-	w, h := e.s.Size()
+	_, h := e.s.Size()
 
-	fp, err := textentry.
-		New(rootdir, "Directory: ", 512).
+	fp, err := e.
+		newentry(rootdir, "Directory: ", 512, "directory").
 		Ask(e.s, 0, h-1)
 	if err != nil {
-		log.Println("[openbuffer, error-ask] ", err)
-		return
+		log.Println("[askrootdir, error-ask] ", err)
+		return "", false
 	}
 	absrootdir, err := filepath.Abs(string(fp))
 	if err != nil {
-		log.Println("[openbuffer, error-abs] ", err)
+		log.Println("[askrootdir, error-abs] ", err)
 		e.statusmsg(fmt.Sprintf("%v", err))
-		return
+		return "", false
 	}
 	if fi, err := os.Stat(absrootdir); err != nil {
-		log.Printf("[openbuffer, stat] %q: %v\n", absrootdir, err)
-		return
+		log.Printf("[askrootdir, stat] %q: %v\n", absrootdir, err)
+		return "", false
 	} else if !fi.IsDir() {
-		log.Printf("[openbuffer, notdir] %q\n", absrootdir)
-		return
+		log.Printf("[askrootdir, notdir] %q\n", absrootdir)
+		return "", false
 	}
-
 	e.prevopendir = absrootdir
+	return absrootdir, true
+}
+
+func (e *Editor) openbuffer() {
+	absrootdir, ok := e.askrootdir()
+	if !ok {
+		return
+	}
+	w, h := e.s.Size()
 	lib := library.New()
 	lib.Add(absrootdir)
 	choices := []fuzzyselect.Entry{}
@@ -684,30 +1442,255 @@ func (e *Editor) openbuffer() {
 		log.Printf("[openbuffer, fuzzy error] %v\n", err)
 		return
 	}
-	fn := string(sel.Display)
+	e.openpath(string(sel.Display))
+}
+
+// openpath opens the file fn into a new buffer. Large files are
+// opened only after confirmation.
+func (e *Editor) openpath(fn string) (buffers.BufferId, bool) {
 	f, err := os.Open(fn)
 	if err != nil {
-		log.Printf("[openbuffer, open error] %v\n", err)
+		log.Printf("[openpath, open error] %v\n", err)
 		e.statusmsg(fmt.Sprintf("Opening file failed: %v", err))
-		return
+		return 0, false
 	}
 	defer f.Close()
 	if fi, err := f.Stat(); err != nil {
-		log.Printf("[openbuffer, stat error] %v\n", err)
+		log.Printf("[openpath, stat error] %v\n", err)
 		e.statusmsg(fmt.Sprintf("Stat failed: %v", err))
 		// This does not have to be a hard failure. We can
 		// proceed cautiously if we managed to open the file
 		// regardless of Stat failing.
 	} else if fi.Size() > config.WARNFILESZ {
-		log.Printf("[openbuffer, too large]: %d\n", fi.Size())
+		log.Printf("[openpath, too large]: %d\n", fi.Size())
 		if !e.askyesno(fmt.Sprintf(
 			"%q is %d MB, do you really want to open it? [y/n]",
 			fi.Name(), fi.Size()/1024/1024)) {
+			return 0, false
+		}
+	}
+	bid, err := e.OpenFile(fn, f)
+	if err != nil {
+		return 0, false
+	}
+	log.Printf("[openpath, done] %q\n", fn)
+	return bid, true
+}
+
+// findbuffer returns the buffer holding the file abspath.
+func (e *Editor) findbuffer(abspath string) (buffers.BufferId, bool) {
+	for bid, eb := range e.buffers.All() {
+		if len(eb.Filepath) == 0 {
+			continue
+		}
+		if fp, err := filepath.Abs(eb.Filepath); err == nil && fp == abspath {
+			return bid, true
+		}
+	}
+	return 0, false
+}
+
+func libraryfiles(absrootdir string) []string {
+	lib := library.New()
+	lib.Add(absrootdir)
+	paths := []string{}
+	lib.Walk(func(abspath string) error {
+		paths = append(paths, abspath)
+		return nil
+	})
+	return paths
+}
+
+// grepfiles searches the files under a directory and jumps to the
+// chosen matching line.
+func (e *Editor) grepfiles() {
+	absrootdir, ok := e.askrootdir()
+	if !ok {
+		return
+	}
+	w, h := e.s.Size()
+	te := e.searchentry("Grep", e.activebuf)
+	var term []rune
+	for {
+		var err error
+		term, err = te.Ask(e.s, 0, h-1)
+		if e.togglesearch(err) {
+			te.SetPrompt(e.searchprompt("Grep", ""))
+			continue
+		}
+		if err != nil || len(term) == 0 {
 			return
 		}
+		break
+	}
+	m, ok := e.compilesearch(term)
+	if !ok {
+		return
+	}
+
+	paths := libraryfiles(absrootdir)
+	results := grep.Files(paths, m)
+	log.Printf("[grepfiles] %q: %d files, %d results\n", string(term), len(paths), len(results))
+	if len(results) == 0 {
+		e.statusmsg(fmt.Sprintf("No matches for %q", string(term)))
+		return
+	}
+	choices := []fuzzyselect.Entry{}
+	for i, res := range results {
+		rel, err := filepath.Rel(absrootdir, res.Filepath)
+		if err != nil {
+			rel = res.Filepath
+		}
+		display := fmt.Sprintf("%s:%d:%s",
+			rel, res.Lineno+1, strings.ReplaceAll(res.Line, "\t", " "))
+		choices = append(choices, fuzzyselect.Entry{Display: []rune(display), Id: uint32(i)})
+	}
+	sel, err := fuzzyselect.New(choices).Choose(e.s, 0, 0, w, h-2)
+	if err != nil {
+		log.Printf("[grepfiles, fuzzy error] %v\n", err)
+		return
+	}
+	res := results[sel.Id]
+	bid, ok := e.findbuffer(res.Filepath)
+	if ok {
+		e.setactivebuf(bid)
+	} else if bid, ok = e.openpath(res.Filepath); !ok {
+		return
+	}
+	eb := e.buffers.Get(bid)
+	if res.Lineno < eb.Buffer.Lines() {
+		eb.SetCursor(res.Lineno, res.Col)
+		eb.Viewport.SetTeleported(eb.CursorLine())
+	}
+}
+
+// replacefiles replaces matches in the files under a directory. The
+// files with matches are listed with their match counts, and the
+// replacement is done either file by file or in all of them. The
+// files are opened as buffers, so they may be reviewed, undone, and
+// saved individually.
+func (e *Editor) replacefiles() {
+	absrootdir, ok := e.askrootdir()
+	if !ok {
+		return
+	}
+	m, to, ok := e.askreplace("Replace in files")
+	if !ok {
+		return
+	}
+	counts := e.countfiles(libraryfiles(absrootdir), m)
+	paths := []string{}
+	for fp := range counts {
+		paths = append(paths, fp)
+	}
+	sort.Strings(paths)
+	log.Printf("[replacefiles] %d files\n", len(paths))
+
+	w, h := e.s.Size()
+	nfiles, nreps := 0, 0
+	for len(paths) > 0 {
+		total := 0
+		choices := []fuzzyselect.Entry{}
+		for i, fp := range paths {
+			rel, err := filepath.Rel(absrootdir, fp)
+			if err != nil {
+				rel = fp
+			}
+			choices = append(choices, fuzzyselect.Entry{
+				Display: []rune(fmt.Sprintf("%s: %d", rel, counts[fp])),
+				Id:      uint32(i + 1),
+			})
+			total += counts[fp]
+		}
+		choices = append([]fuzzyselect.Entry{{
+			Display: []rune(fmt.Sprintf("[all] %d files: %d", len(paths), total)),
+			Id:      0,
+		}}, choices...)
+		sel, err := fuzzyselect.New(choices).Choose(e.s, 0, 0, w, h-2)
+		if err != nil {
+			log.Printf("[replacefiles, fuzzy error] %v\n", err)
+			break
+		}
+		var chosen []string
+		if sel.Id == 0 {
+			chosen, paths = paths, nil
+		} else {
+			i := sel.Id - 1
+			chosen = []string{paths[i]}
+			paths = append(paths[:i:i], paths[i+1:]...)
+		}
+		for _, fp := range chosen {
+			n, ok := e.replacefile(fp, m, to)
+			if !ok {
+				continue
+			}
+			nfiles++
+			nreps += n
+		}
 	}
-	e.NewBuffer(fn, f)
-	log.Printf("[openbuffer, done] %q\n", fn)
+	e.s.Clear()
+	e.drawactivebuf()
+	e.statusmsg(fmt.Sprintf("Replaced %d occurrences in %d files", nreps, nfiles))
+}
+
+// countfiles returns the number of matches in each of the files with
+// matches. Files open in buffers are counted from the buffers, as they
+// are what gets replaced.
+func (e *Editor) countfiles(paths []string, m *search.Matcher) map[string]int {
+	open := map[string]*buffers.EditorBuffer{}
+	for _, eb := range e.buffers.All() {
+		if len(eb.Filepath) == 0 {
+			continue
+		}
+		if fp, err := filepath.Abs(eb.Filepath); err == nil {
+			open[fp] = eb
+		}
+	}
+	ondisk := []string{}
+	counts := map[string]int{}
+	for _, fp := range paths {
+		eb, ok := open[fp]
+		if !ok {
+			ondisk = append(ondisk, fp)
+			continue
+		}
+		limits := &buffer.SearchLimit{
+			EndLineno: eb.Buffer.Lines() - 1,
+			EndCol:    eb.Buffer.LineLength(eb.Buffer.Lines() - 1),
+		}
+		if n := len(eb.Buffer.FindAll(m, limits)); n > 0 {
+			counts[fp] = n
+		}
+	}
+	for fp, n := range grep.Count(ondisk, m) {
+		counts[fp] = n
+	}
+	return counts
+}
+
+// replacefile replaces all matches in the buffer of file fp and makes
+// it the active buffer.
+func (e *Editor) replacefile(fp string, m *search.Matcher, to string) (int, bool) {
+	bid, ok := e.findbuffer(fp)
+	if ok {
+		e.setactivebuf(bid)
+	} else if bid, ok = e.openpath(fp); !ok {
+		return 0, false
+	}
+	eb := e.buffers.Get(bid)
+	limits := &buffer.SearchLimit{
+		EndLineno: eb.Buffer.Lines() - 1,
+		EndCol:    eb.Buffer.LineLength(eb.Buffer.Lines() - 1),
+	}
+	n, last := eb.Buffer.ReplaceAll(m, to, limits)
+	log.Printf("[replacefile] %q: %d\n", fp, n)
+	if n > 0 {
+		eb.SetCursor(last.StartLineno, last.StartCol)
+		eb.Viewport.SetTeleported(eb.CursorLine())
+		e.setmodified(true)
+		e.sethighlighting()
+	}
+	return n, true
 }
 
 func (e *Editor) changebuffer() {
@@ -752,6 +1735,9 @@ func (e *Editor) quit() bool {
 			break
 		}
 	}
+	if err := e.recovery.RemoveAll(); err != nil {
+		log.Printf("[quit, journals] %v\n", err)
+	}
 	return true
 }
 
@@ -767,6 +1753,19 @@ func (e *Editor) Run() error {
 	e.s.Clear()
 	e.drawactivebuf()
 	e.s.Show()
+	for _, msg := range e.queued {
+		e.statusmsg(msg)
+	}
+	e.queued = nil
+	if config.DISKCHECK > 0 {
+		go e.ticker(config.DISKCHECK, diskcheck{})
+	}
+	if e.recovery.Enabled() {
+		e.recover()
+		if config.AUTOSAVE > 0 {
+			go e.ticker(config.AUTOSAVE, autosave{})
+		}
+	}
 main:
 	for {
 		ev := e.s.PollEvent()
@@ -777,85 +1776,25 @@ main:
 			w, h := ev.Size()
 			log.Printf("[resize] w=%d  h=%d\n", w, h)
 			sync = true
+		case *tcell.EventInterrupt:
+			switch ev.Data().(type) {
+			case diskcheck:
+				e.checkdisk()
+			case autosave:
+				e.journal()
+			case chordtimeout:
+				e.timeoutchord(int(ev.Data().(chordtimeout)))
+			case clipboarderror:
+				err := ev.Data().(clipboarderror).err
+				log.Printf("[toclipboard] %v\n", err)
+				e.statusmsg(fmt.Sprintf("Copying to clipboard failed: %v", err))
+			}
 		case *tcell.EventKey:
 			log.Printf("[EventKey] %s (mods=%X)\n", ev.Name(), ev.Modifiers())
-			switch {
-			case ev.Key() == tcell.KeyCtrlF:
-				e.openbuffer()
-			case ev.Key() == tcell.KeyCtrlN:
-				e.NewFromBuffer("", buffer.New(nil))
-			case ev.Key() == tcell.KeyCtrlP:
-				e.changebuffer()
-			case ev.Key() == tcell.KeyCtrlUnderscore:
-				e.undo()
-			case ev.Key() == tcell.KeyCtrlR:
-				e.replace()
-			case ev.Key() == tcell.KeyCtrlS:
-				e.search()
-			case ev.Key() == tcell.KeyCtrlK:
-				e.delline()
-				e.setmodified(true)
-			case ev.Key() == tcell.KeyCtrlG:
-				e.jumpline()
-			case (ev.Modifiers()&tcell.ModAlt > 0) && ev.Rune() == 'f':
-				e.closeactivebuffer(false)
-			case (ev.Modifiers()&tcell.ModAlt > 0) && ev.Key() == tcell.KeyUp:
-				e.jumpempty(true)
-			case (ev.Modifiers()&tcell.ModAlt > 0) && ev.Key() == tcell.KeyDown:
-				e.jumpempty(false)
-			case (ev.Modifiers()&tcell.ModAlt > 0) && ev.Key() == tcell.KeyLeft:
-				e.jumpword(true)
-			case (ev.Modifiers()&tcell.ModAlt > 0) && ev.Key() == tcell.KeyRight:
-				e.jumpword(false)
-			case ev.Key() == tcell.KeyCtrlX:
-				if e.quit() {
-					e.s.Fini()
-					break main
-				}
-			case ev.Key() == tcell.KeyRune:
-				e.insertrune(ev.Rune())
-				e.setmodified(true)
-			case ev.Key() == tcell.KeyEnter:
-				e.insertlinefeed()
-				e.setmodified(true)
-			case ev.Key() == tcell.KeyBackspace, ev.Key() == tcell.KeyBackspace2:
-				if ev.Modifiers()&tcell.ModAlt > 0 {
-					e.backspaceordelword(false)
-				} else {
-					e.backspaceordelword(true)
-				}
-				e.setmodified(true)
-			case ev.Key() == tcell.KeyUp:
-				e.movevertical(true)
-			case ev.Key() == tcell.KeyDown:
-				e.movevertical(false)
-			case ev.Key() == tcell.KeyLeft:
-				e.moveleft()
-			case ev.Key() == tcell.KeyRight:
-				e.moveright()
-			case ev.Key() == tcell.KeyCtrlA:
-				e.moveline(true)
-			case ev.Key() == tcell.KeyCtrlE:
-				e.moveline(false)
-			case ev.Key() == tcell.KeyCtrlW:
-				e.savebuffer()
-			case ev.Key() == tcell.KeyPgUp:
-				e.movepage(true)
-			case ev.Key() == tcell.KeyPgDn:
-				e.movepage(false)
-			case ev.Key() == tcell.KeyTab:
-				eb := e.buffers.Get(e.activebuf)
-				c := config.GetEditorConfig(eb.Filepath)
-				if c.TabSpaces {
-					for i := 0; i < c.TabSize; i++ {
-						e.insertrune(' ')
-					}
-				} else {
-					e.insertrune('\t')
-				}
-				e.setmodified(true)
-			case ev.Key() == tcell.KeyBacktab:
-				e.backtab()
+			e.handlekey(ev)
+			if e.quitting {
+				e.s.Fini()
+				break main
 			}
 		}
 
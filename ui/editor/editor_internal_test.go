@@ -0,0 +1,38 @@
+package editor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestQueuedStatusmsg(t *testing.T) {
+	e := New()
+	if _, err := e.OpenFile("legacy.txt", strings.NewReader("caf\xe9\n")); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.queued) != 1 {
+		t.Fatalf("want one queued message, got %q", e.queued)
+	}
+
+	s := tcell.NewSimulationScreen("UTF-8")
+	s.Init()
+	s.SetSize(60, 3)
+	e.setscreen(s)
+	go e.Run()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		cells, w, h := s.GetContents()
+		last := []rune{}
+		for _, cell := range cells[(h-1)*w:] {
+			last = append(last, cell.Runes...)
+		}
+		if strings.Contains(string(last), "Not UTF-8") {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("queued message was not shown")
+}
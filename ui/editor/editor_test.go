@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/gdamore/tcell/v2"
+	"github.com/susji/ked/config"
 	"github.com/susji/ked/ui/editor"
 )
 
@@ -50,3 +51,22 @@ func TestHello(t *testing.T) {
 		}
 	}
 }
+
+func TestBindKeys(t *testing.T) {
+	table := []struct {
+		bindings []config.KeyBinding
+		ok       bool
+	}{
+		{[]config.KeyBinding{{Key: "Ctrl+O", Command: "open-file"}}, true},
+		{[]config.KeyBinding{{Key: "Ctrl+F", Command: "none"}}, true},
+		{[]config.KeyBinding{{Key: "Ctrl+O", Command: "frobnicate"}}, false},
+		{[]config.KeyBinding{{Key: "Ctrl+Foo", Command: "quit"}}, false},
+	}
+	for _, entry := range table {
+		e := editor.New()
+		err := e.BindKeys(entry.bindings)
+		if (err == nil) != entry.ok {
+			t.Errorf("%+v: got %v", entry.bindings, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package editor
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/susji/ked/buffer"
+	"github.com/susji/ked/config"
+)
+
+// helptext describes the commands with their present key bindings, the
+// configuration file, and the configuration of the buffer in fp.
+func (e *Editor) helptext(fp string) string {
+	b := &strings.Builder{}
+	fmt.Fprintln(b, "ked help")
+	fmt.Fprintln(b)
+	fmt.Fprintln(b, "Commands:")
+	names := commandnames()
+	width := namewidth(names)
+	for _, name := range names {
+		bound := e.bindings(name)
+		if len(bound) == 0 {
+			bound = "unbound"
+		}
+		fmt.Fprintf(b, "  %-*s  %s (%s)\n", width, name, commands[name].doc, bound)
+	}
+	fmt.Fprintln(b)
+
+	if len(config.CONFFILE) > 0 {
+		fmt.Fprintf(b, "Configuration file: %s\n", config.CONFFILE)
+	} else {
+		fmt.Fprintln(b, "Configuration file: none found")
+	}
+	fmt.Fprintln(b, "Configuration file locations:")
+	for _, fn := range config.CONFFILES {
+		fmt.Fprintf(b, "  %s\n", fn)
+	}
+	fmt.Fprintln(b)
+
+	if len(fp) > 0 {
+		fmt.Fprintf(b, "Configuration for %s:\n", fp)
+	} else {
+		fmt.Fprintln(b, "Configuration for buffers without a file:")
+	}
+	c := config.GetEditorConfig(fp)
+	fmt.Fprintf(b, "  tabsize=%d\n", c.TabSize)
+	fmt.Fprintf(b, "  tabspaces=%t\n", c.TabSpaces)
+	if len(c.SaveHook) > 0 {
+		fmt.Fprintf(b, "  savehook=%s\n", strings.Join(c.SaveHook, " "))
+	}
+	for _, hk := range c.HighlightKeywords {
+		fmt.Fprintf(b, "  highlight-keyword=%s\n", hk.Keyword)
+	}
+	for _, hp := range c.HighlightPatterns {
+		fmt.Fprintf(b, "  highlight-pattern=%d:%d:%d:%s\n",
+			hp.Priority, hp.Left, hp.Right, hp.Pattern)
+	}
+	return b.String()
+}
+
+// help opens a read-only buffer with the help text for the active
+// buffer.
+func (e *Editor) help() {
+	fp := e.buffers.Get(e.activebuf).Filepath
+	lines := [][]rune{}
+	for _, line := range strings.Split(strings.TrimSuffix(e.helptext(fp), "\n"), "\n") {
+		lines = append(lines, []rune(line))
+	}
+	bid, err := e.NewFromBuffer("", buffer.New(lines))
+	if err != nil {
+		log.Printf("[help] %v\n", err)
+		return
+	}
+	e.buffers.Get(bid).ReadOnly = true
+}
@@ -0,0 +1,38 @@
+package editor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/susji/ked/config"
+)
+
+func TestHelptext(t *testing.T) {
+	prev := config.CONFFILE
+	config.CONFFILE = "/some/where/ked.ini"
+	defer func() { config.CONFFILE = prev }()
+
+	e := New()
+	err := e.BindKeys([]config.KeyBinding{
+		{Key: "Ctrl+X Ctrl+G", Command: "goto-line"},
+		{Key: "Alt+X", Command: "none"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := e.helptext("")
+	for _, want := range []string{
+		"Configuration file: /some/where/ked.ini",
+		"(Ctrl+G, Ctrl+X Ctrl+G)",
+		"command-palette  ",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("missing %q in:\n%s", want, text)
+		}
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if strings.Contains(line, "command-palette") && !strings.HasSuffix(line, "(unbound)") {
+			t.Errorf("unbound command shown as %q", line)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package editor
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/susji/ked/keys"
+	"github.com/susji/ked/ui/fuzzyselect"
+)
+
+// macroscreen records the keys polled from the screen, and it replays
+// keys as if they were pressed again. As prompts poll the same screen,
+// the keys given to them are recorded and replayed, too.
+type macroscreen struct {
+	tcell.Screen
+	recording bool
+	playing   bool
+	recorded  keys.Sequence
+	replay    []*tcell.EventKey
+}
+
+func (ms *macroscreen) PollEvent() tcell.Event {
+	if len(ms.replay) > 0 {
+		ev := ms.replay[0]
+		ms.replay = ms.replay[1:]
+		return ev
+	}
+	ev := ms.Screen.PollEvent()
+	if kev, ok := ev.(*tcell.EventKey); ok && ms.recording {
+		ms.recorded = append(ms.recorded, keys.FromEvent(kev))
+	}
+	return ev
+}
+
+func (e *Editor) setscreen(s tcell.Screen) {
+	e.ms = &macroscreen{Screen: s}
+	e.s = e.ms
+}
+
+// EnableMacros persists the named macros into path.
+func (e *Editor) EnableMacros(path string) {
+	if len(path) == 0 {
+		return
+	}
+	m, err := keys.LoadMacros(path)
+	if err != nil {
+		log.Printf("[EnableMacros] %v\n", err)
+	}
+	e.macros = m
+}
+
+// recordmacro starts recording a macro or stops the recording.
+func (e *Editor) recordmacro() {
+	if e.ms.playing {
+		return
+	}
+	if !e.ms.recording {
+		log.Println("[recordmacro] start")
+		e.ms.recording = true
+		e.ms.recorded = nil
+		return
+	}
+	e.stoprecording()
+}
+
+// stoprecording keeps the recorded keys as the macro without the keys
+// of the command which stopped the recording.
+func (e *Editor) stoprecording() {
+	e.ms.recording = false
+	end := e.cmdstart
+	if end < 0 {
+		end = 0
+	} else if end > len(e.ms.recorded) {
+		end = len(e.ms.recorded)
+	}
+	e.macro = append(keys.Sequence{}, e.ms.recorded[:end]...)
+	e.ms.recorded = nil
+	log.Printf("[stoprecording] %s\n", e.macro)
+	e.statusmsg(fmt.Sprintf("Recorded a macro of %d keys", len(e.macro)))
+}
+
+// askcount asks how many times to replay a macro.
+func (e *Editor) askcount() (int, bool) {
+	_, h := e.s.Size()
+	raw, err := e.
+		newentry("1", "Replay times: ", 12, "count").
+		Ask(e.s, 0, h-1)
+	if err != nil {
+		log.Println("[askcount, error-ask] ", err)
+		return 0, false
+	}
+	times, err := strconv.Atoi(string(raw))
+	if err != nil || times < 1 {
+		e.statusmsg(fmt.Sprintf("Invalid count: %s", string(raw)))
+		return 0, false
+	}
+	return times, true
+}
+
+// replaymacro stops the recording or replays the latest macro.
+func (e *Editor) replaymacro() {
+	if e.ms.recording {
+		e.stoprecording()
+		return
+	}
+	if e.ms.playing {
+		return
+	}
+	if len(e.macro) == 0 {
+		e.statusmsg("No macro recorded")
+		return
+	}
+	if times, ok := e.askcount(); ok {
+		e.playmacro(e.macro, times)
+	}
+}
+
+// playmacro presses the keys of macro the given times. The edits of the
+// active buffer are undone as one, but undoing and yank-popping within
+// the macro work as they did while recording.
+func (e *Editor) playmacro(macro keys.Sequence, times int) {
+	log.Printf("[playmacro] %d times: %s\n", times, macro)
+	for i := 0; i < times; i++ {
+		for _, k := range macro {
+			e.ms.replay = append(e.ms.replay, tcell.NewEventKey(k.Key, k.Rune, k.Mod))
+		}
+	}
+	b := e.buffers.Get(e.activebuf).Buffer
+	checkpoint := b.Checkpoint()
+	e.ms.playing = true
+	for len(e.ms.replay) > 0 && !e.quitting {
+		if ev, ok := e.s.PollEvent().(*tcell.EventKey); ok {
+			e.handlekey(ev)
+		}
+	}
+	e.ms.playing = false
+	e.ms.replay = nil
+	b.JoinSince(checkpoint)
+}
+
+// savemacro names the latest macro and persists it.
+func (e *Editor) savemacro() {
+	if e.ms.playing {
+		return
+	}
+	if len(e.macro) == 0 {
+		e.statusmsg("No macro recorded")
+		return
+	}
+	_, h := e.s.Size()
+	name, err := e.
+		newentry("", "Macro name: ", 64, "macro").
+		Ask(e.s, 0, h-1)
+	if err != nil {
+		log.Println("[savemacro, error-ask] ", err)
+		return
+	}
+	if err := e.macros.Save(string(name), e.macro); err != nil {
+		log.Printf("[savemacro] %v\n", err)
+		e.statusmsg(fmt.Sprintf("Saving macro failed: %v", err))
+	}
+}
+
+// runmacro replays a saved macro chosen by the user.
+func (e *Editor) runmacro() {
+	if e.ms.playing {
+		return
+	}
+	names := e.macros.Names()
+	if len(names) == 0 {
+		e.statusmsg("No saved macros")
+		return
+	}
+	choices := []fuzzyselect.Entry{}
+	for i, name := range names {
+		macro, _ := e.macros.Get(name)
+		choices = append(choices, fuzzyselect.Entry{
+			Display: []rune(fmt.Sprintf("%s: %s", name, macro)),
+			Id:      uint32(i),
+		})
+	}
+	w, h := e.s.Size()
+	sel, err := fuzzyselect.New(choices).Choose(e.s, 0, 0, w, h-2)
+	if err != nil {
+		log.Printf("[runmacro, fuzzy error] %v\n", err)
+		return
+	}
+	macro, _ := e.macros.Get(names[sel.Id])
+	e.redraw()
+	e.s.Show()
+	if times, ok := e.askcount(); ok {
+		e.playmacro(macro, times)
+	}
+}
@@ -0,0 +1,137 @@
+package editor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/susji/ked/buffer"
+	"github.com/susji/ked/keys"
+)
+
+type macrodone struct{}
+
+// press gives the keys to the editor like Run does. Keys asked by
+// prompts are consumed by the prompts.
+func press(e *Editor, s tcell.SimulationScreen, evs ...*tcell.EventKey) {
+	go func() {
+		for _, ev := range evs {
+			s.PostEventWait(ev)
+		}
+		s.PostEventWait(tcell.NewEventInterrupt(macrodone{}))
+	}()
+	for {
+		switch ev := e.s.PollEvent().(type) {
+		case *tcell.EventKey:
+			e.handlekey(ev)
+		case *tcell.EventInterrupt:
+			if _, ok := ev.Data().(macrodone); ok {
+				return
+			}
+		}
+	}
+}
+
+func runes(text string) []*tcell.EventKey {
+	ret := []*tcell.EventKey{}
+	for _, r := range text {
+		ret = append(ret, tcell.NewEventKey(tcell.KeyRune, r, 0))
+	}
+	return ret
+}
+
+func key(k tcell.Key) *tcell.EventKey {
+	return tcell.NewEventKey(k, 0, 0)
+}
+
+func text(e *Editor) string {
+	return string(e.buffers.Get(e.activebuf).Buffer.Text())
+}
+
+func TestMacroReplay(t *testing.T) {
+	s := tcell.NewSimulationScreen("UTF-8")
+	s.Init()
+	s.SetSize(40, 5)
+	e := NewWithScreen(s)
+	if err := e.keymap.Bind("Ctrl+X E", "macro-replay"); err != nil {
+		t.Fatal(err)
+	}
+	// Replaying asks for the count, which defaults to one.
+	replay3 := []*tcell.EventKey{key(tcell.KeyF4), key(tcell.KeyBackspace2), runes("3")[0], key(tcell.KeyEnter)}
+	undo := ctrl(tcell.KeyCtrlUnderscore)
+
+	table := []struct {
+		name string
+		stop []*tcell.EventKey
+	}{
+		{"key", []*tcell.EventKey{key(tcell.KeyF4)}},
+		{"chord", []*tcell.EventKey{ctrl(tcell.KeyCtrlX), runes("e")[0]}},
+	}
+	for _, entry := range table {
+		e.NewFromBuffer("", buffer.New(nil))
+		press(e, s, key(tcell.KeyF3))
+		press(e, s, runes("ab")...)
+		press(e, s, key(tcell.KeyEnter))
+		// Stopping shows a message, which is dismissed with a key.
+		press(e, s, append(entry.stop, key(tcell.KeyEsc))...)
+
+		want := keys.Sequence{
+			{Key: tcell.KeyRune, Rune: 'a'},
+			{Key: tcell.KeyRune, Rune: 'b'},
+			{Key: tcell.KeyEnter},
+		}
+		if !reflect.DeepEqual(e.macro, want) {
+			t.Errorf("%s: want macro %s, got %s", entry.name, want, e.macro)
+		}
+		if got := text(e); got != "ab\n\n" {
+			t.Errorf("%s: unexpected text after recording %q", entry.name, got)
+		}
+
+		press(e, s, replay3...)
+		if got := text(e); got != "ab\nab\nab\nab\n\n" {
+			t.Errorf("%s: unexpected text after replaying %q", entry.name, got)
+		}
+		press(e, s, undo)
+		if got := text(e); got != "ab\n\n" {
+			t.Errorf("%s: replays should be undone as one, got %q", entry.name, got)
+		}
+	}
+}
+
+func TestMacroUndo(t *testing.T) {
+	s := tcell.NewSimulationScreen("UTF-8")
+	s.Init()
+	s.SetSize(40, 5)
+	e := NewWithScreen(s)
+	e.killring.Push([][]rune{[]rune("x")})
+	replay3 := []*tcell.EventKey{key(tcell.KeyF4), key(tcell.KeyBackspace2), runes("3")[0], key(tcell.KeyEnter)}
+	undo := ctrl(tcell.KeyCtrlUnderscore)
+
+	// Undoing and yank-popping within the macro only touch the edits
+	// of the same replay.
+	table := []struct {
+		name string
+		keys []*tcell.EventKey
+	}{
+		{"undo", []*tcell.EventKey{runes("x")[0], key(tcell.KeyEnter), undo}},
+		{"yank-pop", []*tcell.EventKey{ctrl(tcell.KeyCtrlY), tcell.NewEventKey(tcell.KeyRune, 'y', tcell.ModAlt)}},
+	}
+	for _, entry := range table {
+		e.NewFromBuffer("", buffer.New(nil))
+		press(e, s, key(tcell.KeyF3))
+		press(e, s, entry.keys...)
+		press(e, s, key(tcell.KeyF4), key(tcell.KeyEsc))
+		if got := text(e); got != "x\n" {
+			t.Errorf("%s: unexpected text after recording %q", entry.name, got)
+		}
+
+		press(e, s, replay3...)
+		if got := text(e); got != "xxxx\n" {
+			t.Errorf("%s: unexpected text after replaying %q", entry.name, got)
+		}
+		press(e, s, undo)
+		if got := text(e); got != "x\n" {
+			t.Errorf("%s: replays should be undone as one, got %q", entry.name, got)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package editor
+
+import (
+	"sort"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/susji/ked/buffer"
+	"github.com/susji/ked/config"
+	"github.com/susji/ked/viewport"
+)
+
+// matchset contains search matches in buffer order and the index of
+// the current one, or -1 if there is none. Their column spans are found
+// only for the lines being drawn, so long buffers with many matches are
+// cheap to draw.
+type matchset struct {
+	matches []buffer.Match
+	current int
+}
+
+// matchspan is a column span of a match on a line. The end is
+// exclusive, and a negative end continues to the end of the line.
+type matchspan struct {
+	start, end int
+	current    bool
+}
+
+func newmatchset(matches []buffer.Match, current int) *matchset {
+	return &matchset{
+		matches: matches,
+		current: current,
+	}
+}
+
+// spans returns the spans of the matches on line lineno.
+func (ms *matchset) spans(lineno int) []matchspan {
+	// Matches do not overlap, so their ends are in order, too.
+	i := sort.Search(len(ms.matches), func(i int) bool {
+		return ms.matches[i].EndLineno >= lineno
+	})
+	ret := []matchspan{}
+	for ; i < len(ms.matches) && ms.matches[i].StartLineno <= lineno; i++ {
+		m := ms.matches[i]
+		span := matchspan{start: 0, end: -1, current: i == ms.current}
+		if lineno == m.StartLineno {
+			span.start = m.StartCol
+		}
+		if lineno == m.EndLineno {
+			span.end = m.EndCol
+		}
+		ret = append(ret, span)
+	}
+	return ret
+}
+
+// decorator styles the matches with config.STYLE_MATCH and the current
+// match with config.STYLE_CURRENT_MATCH. The spans of each line are
+// looked up once per drawing.
+func (ms *matchset) decorator() viewport.Decorator {
+	lines := map[int][]matchspan{}
+	return func(lineno, col int, st tcell.Style) tcell.Style {
+		spans, ok := lines[lineno]
+		if !ok {
+			spans = ms.spans(lineno)
+			lines[lineno] = spans
+		}
+		for _, span := range spans {
+			if col >= span.start && (span.end < 0 || col < span.end) {
+				if span.current {
+					return overlay(st, config.STYLE_CURRENT_MATCH)
+				}
+				return overlay(st, config.STYLE_MATCH)
+			}
+		}
+		return st
+	}
+}
+
+// overlay adds the attributes and colors of over to st.
+func overlay(st, over tcell.Style) tcell.Style {
+	fg, bg, attrs := over.Decompose()
+	_, _, stattrs := st.Decompose()
+	st = st.Attributes(stattrs | attrs)
+	if fg != tcell.ColorDefault {
+		st = st.Foreground(fg)
+	}
+	if bg != tcell.ColorDefault {
+		st = st.Background(bg)
+	}
+	return st
+}
@@ -0,0 +1,61 @@
+package editor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/susji/ked/buffer"
+	"github.com/susji/ked/config"
+)
+
+func TestMatchsetSpans(t *testing.T) {
+	ms := newmatchset([]buffer.Match{
+		{StartLineno: 1, StartCol: 2, EndLineno: 1, EndCol: 4},
+		{StartLineno: 1, StartCol: 6, EndLineno: 3, EndCol: 1},
+		{StartLineno: 5, StartCol: 0, EndLineno: 5, EndCol: 2},
+	}, 1)
+	table := []struct {
+		lineno int
+		want   []matchspan
+	}{
+		{0, []matchspan{}},
+		{1, []matchspan{{2, 4, false}, {6, -1, true}}},
+		{2, []matchspan{{0, -1, true}}},
+		{3, []matchspan{{0, 1, true}}},
+		{4, []matchspan{}},
+		{5, []matchspan{{0, 2, false}}},
+		{6, []matchspan{}},
+	}
+	for _, entry := range table {
+		got := ms.spans(entry.lineno)
+		if !reflect.DeepEqual(got, entry.want) {
+			t.Errorf("line %d: want %v, got %v", entry.lineno, entry.want, got)
+		}
+	}
+}
+
+func TestMatchsetDecorator(t *testing.T) {
+	ms := newmatchset([]buffer.Match{
+		{StartLineno: 0, StartCol: 0, EndLineno: 0, EndCol: 2},
+		{StartLineno: 0, StartCol: 4, EndLineno: 0, EndCol: 6},
+	}, 1)
+	deco := ms.decorator()
+	st := tcell.StyleDefault.Bold(true)
+	_, _, attrs := deco(0, 1, st).Decompose()
+	_, _, want := config.STYLE_MATCH.Bold(true).Decompose()
+	if attrs != want {
+		t.Errorf("match: want %v, got %v", want, attrs)
+	}
+	_, _, attrs = deco(0, 4, st).Decompose()
+	_, _, want = config.STYLE_CURRENT_MATCH.Bold(true).Decompose()
+	if attrs != want {
+		t.Errorf("current match: want %v, got %v", want, attrs)
+	}
+	if got := deco(0, 3, st); got != st {
+		t.Errorf("no match: want %v, got %v", st, got)
+	}
+	if config.STYLE_MATCH == config.STYLE_CURRENT_MATCH {
+		t.Error("the current match should have a style of its own")
+	}
+}
@@ -0,0 +1,82 @@
+package editor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/susji/ked/buffer"
+	"github.com/susji/ked/search"
+)
+
+func TestCountFiles(t *testing.T) {
+	dir := t.TempDir()
+	ondisk := filepath.Join(dir, "ondisk.txt")
+	opened := filepath.Join(dir, "opened.txt")
+	for _, fp := range []string{ondisk, opened} {
+		if err := os.WriteFile(fp, []byte("foo\nbar foo\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := New()
+	// The open buffer has unsaved edits, which are what gets replaced.
+	_, err := e.NewFromBuffer(opened, buffer.New([][]rune{
+		[]rune("foo"),
+		[]rune("bar foo foo"),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := search.Compile(`foo\nbar|foo`, search.Options{Regexp: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := e.countfiles([]string{ondisk, opened}, m)
+	want := map[string]int{ondisk: 2, opened: 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestReplaceRegionAnchors(t *testing.T) {
+	table := []struct {
+		pattern string
+		want    []string
+	}{
+		{`^x`, []string{"a x", "y b"}},
+		{`x$`, []string{"a y", "x b"}},
+	}
+	for _, entry := range table {
+		e := New()
+		if _, err := e.NewFromBuffer("", buffer.New([][]rune{
+			[]rune("a x"),
+			[]rune("x b"),
+		})); err != nil {
+			t.Fatal(err)
+		}
+		// The region starts and ends in the middle of a line, so
+		// neither anchor matches at its edges.
+		eb := e.buffers.Get(e.activebuf)
+		eb.SetCursor(0, 2)
+		eb.SetMark()
+		eb.SetCursor(1, 1)
+		limits, ok := e.replacelimits()
+		if !ok {
+			t.Fatal("region should give limits")
+		}
+		m, err := search.Compile(entry.pattern, search.Options{Regexp: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := eb.Buffer.ReplaceAll(m, "y", limits); n != 1 {
+			t.Errorf("%s: want one replacement, got %d", entry.pattern, n)
+		}
+		for i, line := range entry.want {
+			if got := string(eb.Buffer.GetLine(i)); got != line {
+				t.Errorf("%s: want %q, got %q", entry.pattern, line, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package textentry
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/susji/ked/util"
+)
+
+// HISTORYSZ is the number of entries remembered for each kind of
+// prompt.
+const HISTORYSZ = 100
+
+// History remembers the answers given to text entries. Answers are
+// kept separately for each kind of prompt, and if History has a
+// filepath, they are persisted into it.
+type History struct {
+	path    string
+	entries map[string][]string
+}
+
+// NewHistory returns an empty History persisted into path. An empty
+// path keeps the history only in memory.
+func NewHistory(path string) *History {
+	return &History{
+		path:    path,
+		entries: map[string][]string{},
+	}
+}
+
+// LoadHistory reads the History persisted into path. A missing file is
+// not an error. On other errors, the returned History has the entries
+// read so far, and it is not persisted, so that the file is not
+// overwritten with them.
+func LoadHistory(path string) (*History, error) {
+	h, err := loadhistory(path)
+	if err != nil {
+		h.path = ""
+	}
+	return h, err
+}
+
+func loadhistory(path string) (*History, error) {
+	h := NewHistory(path)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return h, nil
+	} else if err != nil {
+		return h, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	lineno := 0
+	for sc.Scan() {
+		lineno++
+		parts := strings.SplitN(sc.Text(), " ", 2)
+		if len(parts) != 2 {
+			return h, fmt.Errorf("%s:%d: missing entry", path, lineno)
+		}
+		kind := parts[0]
+		entry, err := strconv.Unquote(parts[1])
+		if err != nil {
+			return h, fmt.Errorf("%s:%d: %w", path, lineno, err)
+		}
+		h.add(kind, entry)
+	}
+	return h, sc.Err()
+}
+
+// Entries returns the answers for prompts of kind from the oldest to
+// the latest.
+func (h *History) Entries(kind string) []string {
+	return h.entries[kind]
+}
+
+func (h *History) add(kind, entry string) {
+	entries := []string{}
+	for _, prev := range h.entries[kind] {
+		if prev != entry {
+			entries = append(entries, prev)
+		}
+	}
+	entries = append(entries, entry)
+	if len(entries) > HISTORYSZ {
+		entries = entries[len(entries)-HISTORYSZ:]
+	}
+	h.entries[kind] = entries
+}
+
+// Add makes entry the latest answer for prompts of kind and persists
+// the history. Empty entries are ignored.
+func (h *History) Add(kind, entry string) error {
+	if len(entry) == 0 {
+		return nil
+	}
+	h.add(kind, entry)
+	if len(h.path) == 0 {
+		return nil
+	}
+	kinds := []string{}
+	for kind := range h.entries {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	buf := &bytes.Buffer{}
+	for _, kind := range kinds {
+		for _, entry := range h.entries[kind] {
+			fmt.Fprintf(buf, "%s %s\n", kind, strconv.Quote(entry))
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0o700); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(h.path, buf.Bytes(), 0o600)
+}
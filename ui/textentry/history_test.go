@@ -0,0 +1,115 @@
+package textentry_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	tu "github.com/susji/ked/internal/testutil"
+	"github.com/susji/ked/ui/textentry"
+)
+
+func TestHistoryPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ked", "history")
+	h, err := textentry.LoadHistory(path)
+	tu.Assert(t, err == nil, "missing history should be fine: %v", err)
+	tu.Assert(t, h.Add("search", "foo") == nil, "add failed")
+	tu.Assert(t, h.Add("search", "with \"quotes\"\nand newline") == nil, "add failed")
+	tu.Assert(t, h.Add("search", "foo") == nil, "add failed")
+	tu.Assert(t, h.Add("search", "") == nil, "add failed")
+	tu.Assert(t, h.Add("line", "42") == nil, "add failed")
+
+	h, err = textentry.LoadHistory(path)
+	tu.Assert(t, err == nil, "unexpected error: %v", err)
+	want := []string{"with \"quotes\"\nand newline", "foo"}
+	got := h.Entries("search")
+	tu.Assert(t, reflect.DeepEqual(got, want), "want %q, got %q", want, got)
+	got = h.Entries("line")
+	tu.Assert(t, reflect.DeepEqual(got, []string{"42"}), "unexpected %q", got)
+	tu.Assert(t, len(h.Entries("directory")) == 0, "should have no directories")
+}
+
+func TestHistoryLimit(t *testing.T) {
+	h := textentry.NewHistory("")
+	for i := 0; i < textentry.HISTORYSZ+10; i++ {
+		h.Add("search", fmt.Sprintf("%d", i))
+	}
+	got := h.Entries("search")
+	tu.Assert(t, len(got) == textentry.HISTORYSZ, "want %d entries, got %d",
+		textentry.HISTORYSZ, len(got))
+	tu.Assert(t, got[0] == "10", "oldest entries should be dropped, got %q", got[0])
+}
+
+func TestHistoryInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	data := []byte("search \"kept\"\nsearch unquoted\n")
+	tu.Assert(t, os.WriteFile(path, data, 0o600) == nil, "write failed")
+	h, err := textentry.LoadHistory(path)
+	tu.Assert(t, err != nil, "should fail with unquoted entry")
+	tu.Assert(t, reflect.DeepEqual(h.Entries("search"), []string{"kept"}),
+		"unexpected history %q", h.Entries("search"))
+	tu.Assert(t, h.Add("search", "new") == nil, "add failed")
+	got, err := os.ReadFile(path)
+	tu.Assert(t, err == nil, "read failed: %v", err)
+	tu.Assert(t, string(got) == string(data), "invalid history was overwritten: %q", got)
+}
+
+func TestHistoryCustomBinding(t *testing.T) {
+	s := tcell.NewSimulationScreen("UTF-8")
+	s.Init()
+	s.SetSize(40, 2)
+	h := textentry.NewHistory("")
+	next := errors.New("next")
+	te := textentry.New("", "Search: ", 256).
+		SetHistory(h, "search").
+		AddBinding(tcell.KeyCtrlS, next)
+
+	for _, r := range "foo" {
+		s.InjectKey(tcell.KeyRune, r, 0)
+	}
+	s.InjectKey(tcell.KeyCtrlS, 0, 0)
+	_, err := te.Ask(s, 0, 1)
+	tu.Assert(t, errors.Is(err, next), "unexpected error: %v", err)
+	s.InjectKey(tcell.KeyCtrlS, 0, 0)
+	_, err = te.Ask(s, 0, 1)
+	tu.Assert(t, errors.Is(err, next), "unexpected error: %v", err)
+	tu.Assert(t, len(h.Entries("search")) == 0,
+		"custom bindings should not be remembered, got %q", h.Entries("search"))
+
+	s.InjectKey(tcell.KeyEnter, 0, 0)
+	_, err = te.Ask(s, 0, 1)
+	tu.Assert(t, err == nil, "unexpected error: %v", err)
+	tu.Assert(t, reflect.DeepEqual(h.Entries("search"), []string{"foo"}),
+		"unexpected history %q", h.Entries("search"))
+}
+
+func TestHistoryBrowse(t *testing.T) {
+	s := tcell.NewSimulationScreen("UTF-8")
+	s.Init()
+	s.SetSize(40, 2)
+	h := textentry.NewHistory("")
+	h.Add("search", "first")
+	h.Add("search", "second")
+	te := textentry.New("draft", "Search: ", 256).SetHistory(h, "search")
+
+	keys := []tcell.Key{tcell.KeyUp, tcell.KeyUp, tcell.KeyUp, tcell.KeyDown, tcell.KeyEnter}
+	for _, key := range keys {
+		s.InjectKey(key, 0, 0)
+	}
+	answer, err := te.Ask(s, 0, 1)
+	tu.Assert(t, err == nil, "unexpected error: %v", err)
+	tu.Assert(t, string(answer) == "second", "unexpected answer %q", string(answer))
+
+	s.InjectKey(tcell.KeyUp, 0, 0)
+	s.InjectKey(tcell.KeyDown, 0, 0)
+	s.InjectKey(tcell.KeyEnter, 0, 0)
+	answer, err = te.Ask(s, 0, 1)
+	tu.Assert(t, err == nil, "unexpected error: %v", err)
+	tu.Assert(t, string(answer) == "second", "draft should be restored, got %q", string(answer))
+	tu.Assert(t, reflect.DeepEqual(h.Entries("search"), []string{"first", "second"}),
+		"unexpected history %q", h.Entries("search"))
+}
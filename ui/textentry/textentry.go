@@ -3,47 +3,130 @@ package textentry
 import (
 	"errors"
 	"log"
+	"strings"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/susji/ked/config"
-	"github.com/susji/ked/util"
 )
 
 var (
 	ErrorCancelled = errors.New("Text entry was cancelled by user")
-	ErrorTooLong   = errors.New("Too much text entry")
 )
 
 type TextEntry struct {
-	defval, prompt string
-	maxlen         int
-	binds          []bind
+	prompt   string
+	answer   []rune
+	maxlen   int
+	binds    []bind
+	altbinds []altbind
+	history  *History
+	kind     string
+	redraw   func()
 }
 
+// New returns a TextEntry with defval as the initial answer. The
+// answer is kept between calls to Ask.
 func New(defval, prompt string, maxlen int) *TextEntry {
 	return &TextEntry{
-		defval: defval,
+		answer: []rune(defval),
 		prompt: prompt,
 		maxlen: maxlen,
 	}
 }
 
-func (te *TextEntry) draw(s tcell.Screen, prompt, answer []rune, col, lineno int) {
-	// Yes, this is pretty hacky but in practice it will be robust
-	// enough.
+func (te *TextEntry) SetPrompt(prompt string) *TextEntry {
+	te.prompt = prompt
+	return te
+}
+
+// SetHistory makes Up and Down browse the earlier answers to prompts
+// of kind. Answers accepted with Enter are added to the history, but
+// the ones ending the entry with custom bindings are not, as they are
+// often pressed many times for one answer.
+func (te *TextEntry) SetHistory(h *History, kind string) *TextEntry {
+	te.history = h
+	te.kind = kind
+	return te
+}
+
+// remember adds answer into the history.
+func (te *TextEntry) remember(answer []rune) {
+	if te.history == nil {
+		return
+	}
+	if err := te.history.Add(te.kind, string(answer)); err != nil {
+		log.Printf("[textentry, history] %v\n", err)
+	}
+}
+
+// scroll returns the offset of the first visible rune of the answer
+// such that the cursor at cur is visible within width cells. When the
+// answer is scrolled, its first visible rune is covered by a marker,
+// so the cursor is kept to the right of it.
+func scroll(off, cur, width int) int {
+	if width < 2 {
+		return cur
+	}
+	if cur-off >= width {
+		off = cur - width + 1
+	}
+	if cur <= off {
+		off = cur - 1
+	}
+	if off < 0 {
+		off = 0
+	}
+	return off
+}
+
+func (te *TextEntry) draw(s tcell.Screen, prompt, answer []rune, cur, off, col, lineno int) {
 	w, _ := s.Size()
-	answer = util.TruncateLine(answer, w-col-len(prompt), ':')
-	all := append(prompt, answer...)
 	for i := col; i < w; i++ {
 		s.SetContent(i, lineno, ' ', nil, config.STYLE_DEFAULT)
 	}
-	for i, r := range all {
+	for i, r := range prompt {
 		s.SetContent(col+i, lineno, r, nil, config.STYLE_DEFAULT)
 	}
-	s.ShowCursor(col+len(all), lineno)
+	x0 := col + len(prompt)
+	for i, r := range answer[off:] {
+		if x0+i >= w {
+			break
+		}
+		if i == 0 && off > 0 {
+			r = ':'
+		}
+		s.SetContent(x0+i, lineno, r, nil, config.STYLE_DEFAULT)
+	}
+	s.ShowCursor(x0+cur-off, lineno)
 	s.Show()
 }
 
+// isdelim tells whether r separates words when jumping over them.
+func isdelim(r rune) bool {
+	return strings.ContainsRune(config.WORD_DELIMS, r)
+}
+
+// jumpword returns the position of the beginning of the previous word
+// or the end of the next word from cur.
+func jumpword(answer []rune, cur int, left bool) int {
+	if left {
+		for cur > 0 && isdelim(answer[cur-1]) {
+			cur--
+		}
+		for cur > 0 && !isdelim(answer[cur-1]) {
+			cur--
+		}
+		return cur
+	}
+	for cur < len(answer) && isdelim(answer[cur]) {
+		cur++
+	}
+	for cur < len(answer) && !isdelim(answer[cur]) {
+		cur++
+	}
+	return cur
+}
+
 func (te *TextEntry) AddBinding(key tcell.Key, reterr error) *TextEntry {
 	te.binds = append(te.binds, bind{
 		key:    key,
@@ -52,33 +135,152 @@ func (te *TextEntry) AddBinding(key tcell.Key, reterr error) *TextEntry {
 	return te
 }
 
+// AddAltBinding makes Alt+r end the text entry with reterr.
+func (te *TextEntry) AddAltBinding(r rune, reterr error) *TextEntry {
+	te.altbinds = append(te.altbinds, altbind{
+		r:      r,
+		reterr: reterr,
+	})
+	return te
+}
+
+// SetRedraw makes the text entry call fn to redraw the screen behind
+// it when the screen is resized.
+func (te *TextEntry) SetRedraw(fn func()) *TextEntry {
+	te.redraw = fn
+	return te
+}
+
+// Ask lets the user edit the answer on line lineno until it is
+// accepted with Enter, cancelled with Ctrl+C, or one of the custom
+// bindings is pressed. If the entry is on the last line of the
+// screen, it stays there when the screen is resized.
 func (te *TextEntry) Ask(s tcell.Screen, col, lineno int) (answer []rune, reterr error) {
-	answer = []rune(te.defval)
+	answer = te.answer
+	defer func() {
+		te.answer = answer
+	}()
 	prompt := []rune(te.prompt)
+	_, h := s.Size()
+	bottom := lineno == h-1
+	cur, off := len(answer), 0
+	pasting := false
+	// The history is browsed from its end, where we keep the
+	// answer being edited.
+	var entries []string
+	if te.history != nil {
+		entries = te.history.Entries(te.kind)
+	}
+	pos := len(entries)
+	draft := answer
+	setanswer := func(with []rune) {
+		answer = with
+		cur = len(answer)
+	}
+	insert := func(r rune) {
+		if len(answer) >= te.maxlen {
+			return
+		}
+		answer = append(answer[:cur], append([]rune{r}, answer[cur:]...)...)
+		cur++
+	}
+	remove := func(from, to int) {
+		answer = append(answer[:from], answer[to:]...)
+		cur = from
+	}
 	for {
-		te.draw(s, prompt, answer, col, lineno)
+		w, _ := s.Size()
+		off = scroll(off, cur, w-col-len(prompt))
+		te.draw(s, prompt, answer, cur, off, col, lineno)
 
 		ev := s.PollEvent()
 		switch ev := ev.(type) {
+		case *tcell.EventResize:
+			w, h := ev.Size()
+			log.Printf("[text-entry, resize] w=%d  h=%d\n", w, h)
+			if bottom {
+				lineno = h - 1
+			}
+			if te.redraw != nil {
+				te.redraw()
+			}
+			s.Sync()
+		case *tcell.EventPaste:
+			pasting = ev.Start()
 		case *tcell.EventKey:
-			// XXX Resize not handled at all.
 			log.Printf("[text-entry, EventKey] %s (mods=%X)\n",
 				ev.Name(), ev.Modifiers())
+			alt := ev.Modifiers()&tcell.ModAlt > 0
 			switch {
+			case pasting:
+				// Pasted text cannot end the entry.
+				if ev.Key() == tcell.KeyRune {
+					insert(ev.Rune())
+				} else if ev.Key() == tcell.KeyTab {
+					insert('\t')
+				}
 			case ev.Key() == tcell.KeyCtrlC:
 				log.Println("[savebuffer, cancel]")
 				reterr = ErrorCancelled
 				return
-			case ev.Key() == tcell.KeyRune && ev.Modifiers() == 0:
-				answer = append(answer, ev.Rune())
+			case ev.Key() == tcell.KeyRune && !alt:
+				insert(ev.Rune())
+			case ev.Key() == tcell.KeyRune && alt:
+				for _, bind := range te.altbinds {
+					if ev.Rune() == bind.r {
+						log.Printf("[textentry, custom-altbind] %v\n", bind.reterr)
+						reterr = bind.reterr
+						return
+					}
+				}
 			case ev.Key() == tcell.KeyEnter:
+				te.remember(answer)
 				reterr = nil
 				return
+			case ev.Key() == tcell.KeyLeft && alt:
+				cur = jumpword(answer, cur, true)
+			case ev.Key() == tcell.KeyRight && alt:
+				cur = jumpword(answer, cur, false)
+			case ev.Key() == tcell.KeyLeft:
+				if cur > 0 {
+					cur--
+				}
+			case ev.Key() == tcell.KeyRight:
+				if cur < len(answer) {
+					cur++
+				}
+			case ev.Key() == tcell.KeyCtrlA, ev.Key() == tcell.KeyHome:
+				cur = 0
+			case ev.Key() == tcell.KeyCtrlE, ev.Key() == tcell.KeyEnd:
+				cur = len(answer)
+			case ev.Key() == tcell.KeyCtrlK:
+				answer = answer[:cur]
 			case ev.Key() == tcell.KeyBackspace, ev.Key() == tcell.KeyBackspace2:
-				if (ev.Modifiers() & tcell.ModAlt) > 0 {
-					answer = []rune{}
-				} else if len(answer) > 0 {
-					answer = answer[:len(answer)-1]
+				if alt {
+					setanswer([]rune{})
+				} else if cur > 0 {
+					remove(cur-1, cur)
+				}
+			case ev.Key() == tcell.KeyDelete, ev.Key() == tcell.KeyCtrlD:
+				if cur < len(answer) {
+					remove(cur, cur+1)
+				}
+			case ev.Key() == tcell.KeyUp:
+				if pos > 0 {
+					if pos == len(entries) {
+						draft = answer
+					}
+					pos--
+					setanswer([]rune(entries[pos]))
+				}
+			case ev.Key() == tcell.KeyDown:
+				if pos < len(entries) {
+					pos++
+					if pos == len(entries) {
+						setanswer(draft)
+					} else {
+						setanswer([]rune(entries[pos]))
+					}
 				}
 			default:
 				for _, bind := range te.binds {
@@ -89,11 +291,6 @@ func (te *TextEntry) Ask(s tcell.Screen, col, lineno int) (answer []rune, reterr
 					}
 				}
 			}
-
-		}
-		if len(answer) >= te.maxlen {
-			reterr = ErrorTooLong
-			return
 		}
 	}
 }
@@ -102,3 +299,8 @@ type bind struct {
 	key    tcell.Key
 	reterr error
 }
+
+type altbind struct {
+	r      rune
+	reterr error
+}
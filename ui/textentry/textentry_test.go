@@ -0,0 +1,153 @@
+package textentry_test
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	tu "github.com/susji/ked/internal/testutil"
+	"github.com/susji/ked/ui/textentry"
+)
+
+type key struct {
+	k   tcell.Key
+	r   rune
+	mod tcell.ModMask
+}
+
+func runes(what string) []key {
+	ret := []key{}
+	for _, r := range what {
+		ret = append(ret, key{tcell.KeyRune, r, 0})
+	}
+	return ret
+}
+
+func keys(ks ...tcell.Key) []key {
+	ret := []key{}
+	for _, k := range ks {
+		ret = append(ret, key{k, 0, 0})
+	}
+	return ret
+}
+
+func newscreen(t *testing.T, w, h int) tcell.SimulationScreen {
+	s := tcell.NewSimulationScreen("UTF-8")
+	tu.Assert(t, s.Init() == nil, "cannot init screen")
+	s.SetSize(w, h)
+	return s
+}
+
+// ask answers te with events. The events are posted as the entry
+// consumes them, as the simulation screen drops events when its queue
+// is full.
+func ask(t *testing.T, s tcell.SimulationScreen, te *textentry.TextEntry, events ...tcell.Event) string {
+	go func() {
+		for _, ev := range events {
+			s.PostEventWait(ev)
+		}
+	}()
+	_, h := s.Size()
+	answer, err := te.Ask(s, 0, h-1)
+	tu.Assert(t, err == nil, "unexpected error: %v", err)
+	return string(answer)
+}
+
+func events(kss ...[]key) []tcell.Event {
+	ret := []tcell.Event{}
+	for _, ks := range kss {
+		for _, k := range ks {
+			ret = append(ret, tcell.NewEventKey(k.k, k.r, k.mod))
+		}
+	}
+	return ret
+}
+
+func line(s tcell.SimulationScreen, lineno int) string {
+	cells, w, _ := s.GetContents()
+	ret := []rune{}
+	for i := 0; i < w; i++ {
+		ret = append(ret, cells[lineno*w+i].Runes[0])
+	}
+	return string(ret)
+}
+
+func TestEditing(t *testing.T) {
+	table := []struct {
+		name, defval string
+		keys         [][]key
+		want         string
+	}{
+		{"append", "ab", [][]key{runes("cd")}, "abcd"},
+		{"insert", "ad", [][]key{keys(tcell.KeyLeft), runes("bc")}, "abcd"},
+		{"home", "bc", [][]key{keys(tcell.KeyCtrlA), runes("a"), keys(tcell.KeyCtrlE), runes("d")}, "abcd"},
+		{"backspace", "abxc", [][]key{keys(tcell.KeyLeft, tcell.KeyBackspace2)}, "abc"},
+		{"delete", "abxc", [][]key{keys(tcell.KeyHome, tcell.KeyRight, tcell.KeyRight, tcell.KeyDelete)}, "abc"},
+		{"kill", "abc def", [][]key{keys(tcell.KeyLeft, tcell.KeyLeft, tcell.KeyLeft, tcell.KeyCtrlK)}, "abc "},
+		{"words", "one two three", [][]key{
+			{{tcell.KeyLeft, 0, tcell.ModAlt}, {tcell.KeyLeft, 0, tcell.ModAlt}},
+			runes("x"),
+			{{tcell.KeyRight, 0, tcell.ModAlt}},
+			runes("y")}, "one xtwoy three"},
+		{"clear", "abc", [][]key{{{tcell.KeyBackspace2, 0, tcell.ModAlt}}, runes("d")}, "d"},
+		{"bounds", "a", [][]key{keys(tcell.KeyRight, tcell.KeyLeft, tcell.KeyLeft, tcell.KeyBackspace2)}, "a"},
+	}
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			s := newscreen(t, 40, 2)
+			te := textentry.New(e.defval, "> ", 100)
+			evs := events(append(e.keys, keys(tcell.KeyEnter))...)
+			got := ask(t, s, te, evs...)
+			tu.Assert(t, got == e.want, "got %q, want %q", got, e.want)
+		})
+	}
+}
+
+func TestMaxlen(t *testing.T) {
+	s := newscreen(t, 40, 2)
+	te := textentry.New("ab", "> ", 4)
+	got := ask(t, s, te, events(runes("cdef"), keys(tcell.KeyEnter))...)
+	tu.Assert(t, got == "abcd", "want answer limited to four runes, got %q", got)
+}
+
+func TestScroll(t *testing.T) {
+	s := newscreen(t, 10, 2)
+	te := textentry.New("/very/long/path", "> ", 100)
+	got := ask(t, s, te, events(runes("x"), keys(tcell.KeyEnter))...)
+	tu.Assert(t, got == "/very/long/pathx", "unexpected answer %q", got)
+	tu.Assert(t, line(s, 1) == "> :/pathx ", "unexpected drawing %q", line(s, 1))
+	x, y, _ := s.GetCursor()
+	tu.Assert(t, x == 9 && y == 1, "unexpected cursor %d, %d", x, y)
+
+	got = ask(t, s, te, events(keys(tcell.KeyHome, tcell.KeyRight, tcell.KeyEnter))...)
+	tu.Assert(t, line(s, 1) == "> /very/lo", "unexpected drawing %q", line(s, 1))
+	x, _, _ = s.GetCursor()
+	tu.Assert(t, x == 3, "unexpected cursor %d", x)
+}
+
+func TestPaste(t *testing.T) {
+	s := newscreen(t, 40, 2)
+	te := textentry.New("", "> ", 100)
+	evs := []tcell.Event{tcell.NewEventPaste(true)}
+	evs = append(evs, events(runes("a"), keys(tcell.KeyEnter, tcell.KeyCtrlC), runes("b"))...)
+	evs = append(evs, tcell.NewEventPaste(false))
+	evs = append(evs, events(keys(tcell.KeyEnter))...)
+	got := ask(t, s, te, evs...)
+	tu.Assert(t, got == "ab", "pasted keys should not end the entry, got %q", got)
+}
+
+func TestResize(t *testing.T) {
+	s := newscreen(t, 20, 4)
+	redrawn := false
+	te := textentry.New("abc", "> ", 100).SetRedraw(func() { redrawn = true })
+	go func() {
+		s.PostEventWait(tcell.NewEventKey(tcell.KeyRune, 'd', 0))
+		s.SetSize(20, 6)
+		s.PostEventWait(tcell.NewEventResize(20, 6))
+		s.PostEventWait(tcell.NewEventKey(tcell.KeyEnter, 0, 0))
+	}()
+	answer, err := te.Ask(s, 0, 3)
+	tu.Assert(t, err == nil, "unexpected error: %v", err)
+	tu.Assert(t, string(answer) == "abcd", "unexpected answer %q", string(answer))
+	tu.Assert(t, redrawn, "should have redrawn")
+	tu.Assert(t, line(s, 5)[:6] == "> abcd", "entry should move to last line, got %q", line(s, 5))
+}
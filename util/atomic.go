@@ -0,0 +1,98 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// WriteFileAtomic replaces the contents of path with data such that a
+// crash or a full disk will not leave a truncated file behind. This is
+// done by writing a temporary file into the same directory, syncing
+// it, and renaming it over the original file. The mode and ownership
+// of an existing file are preserved, and new files get newmode masked
+// by the umask. If path is a symbolic link, the file it points to is
+// replaced.
+func WriteFileAtomic(path string, data []byte, newmode fs.FileMode) error {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	fi, err := os.Stat(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	dir, base := filepath.Split(path)
+	f, err := createtemp(dir, "."+base+".ked-", newmode)
+	if err != nil {
+		return fmt.Errorf("cannot create temporary file: %w", err)
+	}
+	tmppath := f.Name()
+	// Do not leave partial files around if anything fails.
+	renamed := false
+	defer func() {
+		if !renamed {
+			os.Remove(tmppath)
+		}
+	}()
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot write temporary file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot sync temporary file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("cannot close temporary file: %w", err)
+	}
+	if fi != nil {
+		if err := os.Chmod(tmppath, fi.Mode().Perm()); err != nil {
+			return fmt.Errorf("cannot set file mode: %w", err)
+		}
+		// Changing the owner requires privileges we do not
+		// usually have, so this is just a best effort.
+		if err := chown(tmppath, fi); err != nil {
+			log.Printf("[WriteFileAtomic, chown] %v\n", err)
+		}
+	}
+	if err := os.Rename(tmppath, path); err != nil {
+		return fmt.Errorf("cannot replace file: %w", err)
+	}
+	renamed = true
+	syncdir(dir)
+	return nil
+}
+
+// createtemp creates a new file in dir like os.CreateTemp, but with
+// mode instead of 0600. The umask is applied to mode.
+func createtemp(dir, prefix string, mode fs.FileMode) (*os.File, error) {
+	for i := 0; i < 10000; i++ {
+		path := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10))
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, mode)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		return f, err
+	}
+	return nil, fmt.Errorf("too many temporary files in %q", dir)
+}
+
+// syncdir makes the rename durable. Not all platforms support syncing
+// directories, so errors are ignored.
+func syncdir(dir string) {
+	if len(dir) == 0 {
+		dir = "."
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	d.Sync()
+}
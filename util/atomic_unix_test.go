@@ -0,0 +1,29 @@
+//go:build !windows
+// +build !windows
+
+package util_test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	tu "github.com/susji/ked/internal/testutil"
+	"github.com/susji/ked/util"
+)
+
+func TestWriteFileAtomicUmask(t *testing.T) {
+	prev := syscall.Umask(077)
+	defer syscall.Umask(prev)
+
+	fn := filepath.Join(t.TempDir(), "private.txt")
+	if err := util.WriteFileAtomic(fn, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tu.Assert(t, fi.Mode().Perm() == 0600, "umask should apply to new files: %v", fi.Mode())
+}
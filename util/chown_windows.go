@@ -0,0 +1,7 @@
+package util
+
+import "io/fs"
+
+func chown(path string, fi fs.FileInfo) error {
+	return nil
+}
@@ -1,6 +1,11 @@
 package util
 
-import "strings"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 func TruncateLine(rs []rune, width int, pad rune) []rune {
 	if width <= 0 {
@@ -47,3 +52,39 @@ var unescaper = strings.NewReplacer(
 func Unescape(raw string) string {
 	return unescaper.Replace(raw)
 }
+
+var ErrorInvalidRange = errors.New("invalid line range")
+
+// ParseLineRange parses a range of one-based line numbers like "10,40"
+// for a buffer of nlines lines. Either end may be omitted, a single
+// number means one line, and "%" means all lines. The returned range
+// is zero-based and inclusive.
+func ParseLineRange(raw string, nlines int) (start, end int, err error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "%" {
+		return 0, nlines - 1, nil
+	}
+	parts := strings.Split(raw, ",")
+	if len(parts) > 2 {
+		return 0, 0, fmt.Errorf("%w: %q", ErrorInvalidRange, raw)
+	}
+	if len(parts) == 1 {
+		parts = append(parts, parts[0])
+	}
+	ends := []int{1, nlines}
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 1 || n > nlines {
+			return 0, 0, fmt.Errorf("%w: %q", ErrorInvalidRange, raw)
+		}
+		ends[i] = n
+	}
+	if len(raw) == 0 || ends[0] > ends[1] {
+		return 0, 0, fmt.Errorf("%w: %q", ErrorInvalidRange, raw)
+	}
+	return ends[0] - 1, ends[1] - 1, nil
+}
@@ -1,7 +1,10 @@
 package util_test
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -79,3 +82,128 @@ here
 		})
 	}
 }
+
+func TestParseLineRange(t *testing.T) {
+	table := []struct {
+		give       string
+		start, end int
+		ok         bool
+	}{
+		{"10,40", 9, 39, true},
+		{" 3 , 5 ", 2, 4, true},
+		{"7", 6, 6, true},
+		{"%", 0, 49, true},
+		{"5,", 4, 49, true},
+		{",5", 0, 4, true},
+		{",", 0, 49, true},
+		{"", 0, 0, false},
+		{"0,3", 0, 0, false},
+		{"3,51", 0, 0, false},
+		{"5,3", 0, 0, false},
+		{"1,2,3", 0, 0, false},
+		{"a,b", 0, 0, false},
+	}
+
+	for _, e := range table {
+		t.Run(e.give, func(t *testing.T) {
+			start, end, err := util.ParseLineRange(e.give, 50)
+			if !e.ok {
+				tu.Assert(t, errors.Is(err, util.ErrorInvalidRange), "want error, got %v", err)
+				return
+			}
+			tu.Assert(t, err == nil, "unexpected error: %v", err)
+			tu.Assert(t, start == e.start && end == e.end,
+				"got %d-%d, want %d-%d", start, end, e.start, e.end)
+		})
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "script.sh")
+
+	// New files get the given mode.
+	if err := util.WriteFileAtomic(fn, []byte("first"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tu.Assert(t, fi.Mode().Perm() == 0600, "unexpected mode: %v", fi.Mode())
+
+	// Existing files keep their mode.
+	if err := os.Chmod(fn, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteFileAtomic(fn, []byte("second"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fi, err = os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tu.Assert(t, fi.Mode().Perm() == 0755, "unexpected mode: %v", fi.Mode())
+
+	// Symbolic links are followed and kept intact.
+	link := filepath.Join(dir, "link.sh")
+	if err := os.Symlink(fn, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteFileAtomic(link, []byte("third"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	lfi, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tu.Assert(t, lfi.Mode()&os.ModeSymlink != 0, "link should still be a symlink")
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tu.Assert(t, string(data) == "third", "unexpected contents: %q", data)
+
+	// No temporary files should be left behind.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tu.Assert(t, len(entries) == 2, "unexpected directory entries: %v", entries)
+
+	err = util.WriteFileAtomic(filepath.Join(dir, "missing", "file"), []byte("x"), 0600)
+	tu.Assert(t, err != nil, "writing into a missing directory should fail")
+
+	// Failing writes should leave the original intact. A non-empty
+	// directory cannot be replaced by a file.
+	target := filepath.Join(dir, "target")
+	inside := filepath.Join(target, "inside")
+	if err := os.Mkdir(target, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(inside, []byte("original"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = util.WriteFileAtomic(target, []byte("x"), 0600)
+	tu.Assert(t, err != nil, "replacing a directory should fail")
+	data, err = os.ReadFile(inside)
+	tu.Assert(t, err == nil && string(data) == "original", "original changed: %q, %v", data, err)
+	entries, err = os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tu.Assert(t, len(entries) == 3, "temporary file left behind: %v", entries)
+
+	// Permissions do not restrict root.
+	if os.Geteuid() == 0 {
+		return
+	}
+	if err := os.Chmod(dir, 0500); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0700)
+	err = util.WriteFileAtomic(fn, []byte("fourth"), 0600)
+	tu.Assert(t, err != nil, "writing into a read-only directory should fail")
+	data, err = os.ReadFile(fn)
+	tu.Assert(t, err == nil && string(data) == "third", "original changed: %q, %v", data, err)
+}
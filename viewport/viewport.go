@@ -101,6 +101,11 @@ func New(buffer *buffer.Buffer) *Viewport {
 type RenderFunc func(lineno, col int, line []rune)
 type CursorFunc func(lineno, col int)
 
+// Decorator may override the style of a single rune after
+// highlighting has been applied. This is used for things like
+// displaying the region.
+type Decorator func(lineno, col int, st tcell.Style) tcell.Style
+
 func getpadding(howmuch int) []rune {
 	ret := make([]rune, howmuch)
 	for i := 0; i < howmuch; i++ {
@@ -109,53 +114,81 @@ func getpadding(howmuch int) []rune {
 	return ret
 }
 
+// tabcount returns the number of tabs in what before col.
+func tabcount(what []rune, col int) int {
+	ret := 0
+	for _, r := range what[:col] {
+		if r == '\t' {
+			ret++
+		}
+	}
+	return ret
+}
+
+// tabexpand expands tabs into spaces and resolves the style of each
+// drawn rune. As lines may be very long, we stop once limit drawn
+// runes have been produced.
 func tabexpand(
-	lineno int, what []rune, tabsz int, hilite highlighting.Highlighting) (
-	[]rune, []int, []tcell.Style) {
+	lineno int, what []rune, tabsz int, hilite highlighting.Highlighting,
+	decos []Decorator, limit int) ([]rune, []tcell.Style) {
 
 	exp := []rune("                                        ")
-	new := make([]rune, 0, len(what))
-	tabbedlen := make([]int, 0, len(what)+1)
-	tabbedlen = append(tabbedlen, 0)
-	styles := make([]tcell.Style, 0, len(what)+1)
-	ntabs := 0
+	new := make([]rune, 0, limit+tabsz)
+	styles := make([]tcell.Style, 0, limit+tabsz)
 	for col, r := range what {
+		if len(new) >= limit {
+			break
+		}
 		st := hilite.Get(lineno, col)
+		for _, deco := range decos {
+			st = deco(lineno, col, st)
+		}
 		if r != '\t' {
 			new = append(new, r)
 			styles = append(styles, st)
 		} else {
 			new = append(new, exp[:tabsz]...)
-			ntabs++
 			for i := 0; i < tabsz; i++ {
 				styles = append(styles, st)
 			}
 		}
-		tabbedlen = append(tabbedlen, ntabs*(tabsz-1))
 	}
-	return new, tabbedlen, styles
+	return new, styles
 }
 
+// doRenderWrapped splits a buffer line into fragments of width w. At
+// most maxfrags fragments are actually rendered, but the returned
+// count includes all of them.
 func (v *Viewport) doRenderWrapped(
-	w, cursorlineno, cursorcol, linenobuf, linenodrawn int, line []rune,
-	hilite highlighting.Highlighting) (
-	[]renderedLine, int, int) {
+	w, maxfrags, cursorlineno, cursorcol, linenobuf, linenodrawn int, line []rune,
+	hilite highlighting.Highlighting, decos []Decorator) (
+	[]renderedLine, int, int, int) {
 
-	ret := []renderedLine{}
-	line, tabbedlen, styles := tabexpand(linenobuf, line, v.buffer.TabSize, hilite)
-	nlinefrag := int(math.Ceil(float64(len(line)) / float64(w)))
+	tabsz := v.buffer.TabSize
+	expandedlen := len(line) + tabcount(line, len(line))*(tabsz-1)
+	nlinefrag := int(math.Ceil(float64(expandedlen) / float64(w)))
+	// Zero fragments means one line still.
+	nfrags := nlinefrag
+	if nfrags == 0 {
+		nfrags = 1
+	}
+	if maxfrags > nfrags {
+		maxfrags = nfrags
+	} else if maxfrags < 0 {
+		maxfrags = 0
+	}
 
 	// As we're wrapping the display, long lines need to split
 	// into line fragments, which are rendered on their own
-	// terminal rows. Also, we need similar logic to figure out
-	// our cursor position.
-	cx := -1
-	cy := -1
-	for i := 0; i < nlinefrag; i++ {
-		start := i * w
+	// terminal rows. Only the fragments which may be drawn are
+	// tab-expanded and styled.
+	ret := []renderedLine{}
+	expanded, styles := tabexpand(linenobuf, line, tabsz, hilite, decos, maxfrags*w)
+	for i := 0; i < maxfrags; i++ {
+		start := int(math.Min(float64(i*w), float64(len(expanded))))
 		endraw := (i + 1) * w
-		end := int(math.Min(float64(endraw), float64(len(line))))
-		drawfrag := line[start:end]
+		end := int(math.Min(float64(endraw), float64(len(expanded))))
+		drawfrag := expanded[start:end]
 		stylefrag := styles[start:end]
 
 		if endraw > end {
@@ -169,26 +202,23 @@ func (v *Viewport) doRenderWrapped(
 			content: drawfrag,
 			styles:  stylefrag,
 		})
-
-		if linenobuf == cursorlineno &&
-			(cursorcol+tabbedlen[cursorcol]) >= start &&
-			(cursorcol+tabbedlen[cursorcol]) <= end {
-			cx = cursorcol - start + tabbedlen[cursorcol]
-			cy = linenodrawn + i
-		}
 	}
-	// Zero fragments means one line still.
-	if nlinefrag == 0 {
-		ret = append(ret, renderedLine{
-			content: getpadding(w),
-			styles:  nil,
-		})
-		if linenobuf == cursorlineno {
-			cx = 0
-			cy = linenodrawn
+
+	// Figure out the fragment containing the cursor. A cursor at
+	// the very end of a line filling its last fragment is placed
+	// past the fragment.
+	cx := -1
+	cy := -1
+	if linenobuf == cursorlineno {
+		x := cursorcol + tabcount(line, cursorcol)*(tabsz-1)
+		i := x / w
+		if i > nfrags-1 {
+			i = nfrags - 1
 		}
+		cx = x - i*w
+		cy = linenodrawn + i
 	}
-	return ret, cx, cy
+	return ret, nfrags, cx, cy
 }
 
 type historySumStack struct {
@@ -222,7 +252,8 @@ func (v *Viewport) checktranslation(cursorlineno int) {
 }
 
 func (v *Viewport) Render(
-	w, h, cursorlineno, cursorcol int, hilite highlighting.Highlighting) *Rendering {
+	w, h, cursorlineno, cursorcol int, hilite highlighting.Highlighting,
+	decos ...Decorator) *Rendering {
 	if v.paged {
 		v.paged = false
 	} else {
@@ -315,8 +346,14 @@ func (v *Viewport) Render(
 	for ; n < lastbufline && state != VIEWPORT_AFTER; n++ {
 		line := v.buffer.GetLine(n)
 		//log.Printf("[Render=%d] line=%q\n", linenobuf, string(line))
-		renderedlines, _cx, _cy := v.doRenderWrapped(
-			w, cursorlineno, cursorcol, n, linenodrawn, line, hilite)
+		// Lines above the viewport are only counted, and lines
+		// within it are rendered only as far as they are visible.
+		maxfrags := 0
+		if n >= v.y0 {
+			maxfrags = h - linesdrawninview
+		}
+		renderedlines, nfrags, _cx, _cy := v.doRenderWrapped(
+			w, maxfrags, cursorlineno, cursorcol, n, linenodrawn, line, hilite, decos)
 
 		//
 		// Only lines drawn within the current viewport are
@@ -336,7 +373,7 @@ func (v *Viewport) Render(
 				renderlines = append(renderlines, rl)
 			}
 			linesbufinview++
-			linesdrawninview += len(renderedlines)
+			linesdrawninview += nfrags
 			if _cx != -1 && _cy != -1 {
 				cx = _cx
 				cy = _cy - linesdrawnpreview
@@ -367,8 +404,8 @@ func (v *Viewport) Render(
 				// lines we need to scroll half a page
 				// upwards. This matches when we are still
 				// upwards from our viewport.
-				hss.Push(len(renderedlines))
-				linesdrawnpreview += len(renderedlines)
+				hss.Push(nfrags)
+				linesdrawnpreview += nfrags
 			}
 		case VIEWPORT_FIRST_HALF:
 			// Similar to the case of counting
@@ -390,7 +427,7 @@ func (v *Viewport) Render(
 				v.pagedown = n
 			}
 		}
-		linenodrawn += len(renderedlines)
+		linenodrawn += nfrags
 	}
 	// It may be we have only a partial viewport to render. In
 	// that case, we do not have scanned values for down-limits